@@ -5,13 +5,25 @@ import (
 	"matching_engine/pkg/streaming"
 )
 
+const (
+	eventLogPath = "events.log"
+	snapshotDir  = "snapshots"
+)
+
 func main() {
-	store, err := streaming.NewEventStore("events.log", true)
+	store, err := streaming.NewEventStore(eventLogPath, true)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer store.Close()
 
+	// Recover before the server starts taking events, so every logged order
+	// is either replayed here or polled by the engine, never both.
+	me, err := recoverMatchingEngine(snapshotDir, eventLogPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	topics := []*streaming.Topic{
 		{
 			Name: "order",
@@ -39,7 +51,7 @@ func main() {
 	bus := streaming.NewEventBus(1024, store, topicManager)
 	server := streaming.NewServer(bus)
 
-	go startMatchingEngine()
+	go startMatchingEngine(me)
 
 	log.Println("Event streaming server started on :8081")
 	if err := server.ListenAndServe(8081); err != nil {
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"matching_engine/pkg/matching"
+	"matching_engine/pkg/streaming"
+	"matching_engine/pkg/streaming/proto"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
 )
 
 var upgrader = websocket.Upgrader{
@@ -52,12 +62,212 @@ func TestWebsocketHandler(t *testing.T) {
 	me.GetOrderBook().AddOrder(&matching.BookOrder{ID: order.ID, Side: order.Side, Price: order.Price, Quantity: order.Quantity})
 	me.TakeSnapshot()
 
-	var event matching.Event
+	var event struct {
+		Symbol string
+		Report matching.BookSnapshot
+	}
 	if err := ws.ReadJSON(&event); err != nil {
 		t.Fatalf("could not read json from ws: %v", err)
 	}
 
-	if _, ok := event.Data.(string); !ok || !strings.HasPrefix(event.Data.(string), "SNAPSHOT:") {
-		t.Errorf("Expected a snapshot event, but got %s", event.Data)
+	if len(event.Report.Bids) != 1 || event.Report.Bids[0].OrderID != 1 {
+		t.Errorf("Expected a snapshot with bid 1, but got %+v", event.Report)
+	}
+}
+
+func TestDepthHandler(t *testing.T) {
+	me := matching.NewMatchingEngine(nil)
+	me.PlaceOrder(&matching.Order{ID: 1, Type: "limit", Side: "buy", Price: 99 * matching.PricePrecision, Quantity: 10})
+	me.PlaceOrder(&matching.Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * matching.PricePrecision, Quantity: 5})
+	me.PlaceOrder(&matching.Order{ID: 3, Type: "limit", Side: "sell", Price: 101 * matching.PricePrecision, Quantity: 7})
+
+	handler := depthHandler(me, &sync.Mutex{})
+
+	t.Run("should serve the top levels as json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/depth?levels=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", rec.Code)
+		}
+		var depth matching.Depth
+		if err := json.NewDecoder(rec.Body).Decode(&depth); err != nil {
+			t.Fatalf("could not decode depth: %v", err)
+		}
+		if len(depth.Bids) != 1 || depth.Bids[0].Price != 100*matching.PricePrecision || depth.Bids[0].Quantity != 5 {
+			t.Errorf("Expected best bid level 100 x 5, got %v", depth.Bids)
+		}
+		if len(depth.Asks) != 1 || depth.Asks[0].Price != 101*matching.PricePrecision {
+			t.Errorf("Expected best ask level 101, got %v", depth.Asks)
+		}
+	})
+
+	t.Run("should reject an unknown symbol", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/depth?symbol=ETH-USD", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", rec.Code)
+		}
+	})
+
+	t.Run("should reject invalid levels", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, "/depth?levels=abc", nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rec.Code)
+		}
+	})
+}
+
+func TestRecoverMatchingEngine(t *testing.T) {
+	orders := []*matching.Order{
+		{ID: 1, Type: "limit", Side: "sell", Price: 101 * matching.PricePrecision, Quantity: 5},
+		{ID: 2, Type: "limit", Side: "sell", Price: 102 * matching.PricePrecision, Quantity: 5},
+		{ID: 3, Type: "stop-loss", Side: "buy", TriggerPrice: 101 * matching.PricePrecision, Quantity: 2},
+		{ID: 4, Type: "limit", Side: "buy", Price: 99 * matching.PricePrecision, Quantity: 4},
+		{ID: 5, Type: "market", Side: "buy", Quantity: 3},
+		{ID: 6, Type: "limit", Side: "sell", Price: 99 * matching.PricePrecision, Quantity: 1},
+	}
+
+	// writeLog stores the orders, with an unrelated event between them, the
+	// way the event bus does.
+	writeLog := func(t *testing.T, path string) {
+		t.Helper()
+		store, err := streaming.NewEventStore(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		for i, order := range orders {
+			payload, err := json.Marshal(order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Store(&streaming.Event{Topic: "order", Payload: payload}); err != nil {
+				t.Fatal(err)
+			}
+			if i == 2 {
+				if err := store.Store(&streaming.Event{Topic: "snapshot", Payload: []byte(`{}`)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	// expectedState is the state of an engine that processed the first n
+	// orders without interruption.
+	expectedState := func(n int) []byte {
+		me := matching.NewMatchingEngine(nil)
+		for _, order := range orders[:n] {
+			copied := *order
+			me.PlaceOrder(&copied)
+		}
+		return me.Snapshot()
+	}
+
+	t.Run("replays the whole log without a snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "events.log")
+		writeLog(t, logPath)
+
+		me, err := recoverMatchingEngine(filepath.Join(dir, "snapshots"), logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(me.Snapshot(), expectedState(len(orders))) {
+			t.Error("expected the recovered engine to match one that never stopped")
+		}
+	})
+
+	t.Run("replays only the orders after the snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "events.log")
+		snapshotDir := filepath.Join(dir, "snapshots")
+		writeLog(t, logPath)
+
+		snapshotted, err := matching.Restore(nil, expectedState(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := matching.WriteSnapshotFile(snapshotDir, snapshotted.InputSequence(), snapshotted.Snapshot()); err != nil {
+			t.Fatal(err)
+		}
+
+		me, err := recoverMatchingEngine(snapshotDir, logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(me.Snapshot(), expectedState(len(orders))) {
+			t.Error("expected the recovered engine to match one that never stopped")
+		}
+	})
+
+	t.Run("recovers up to a torn record", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "events.log")
+		writeLog(t, logPath)
+		info, err := os.Stat(logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(logPath, info.Size()-5); err != nil {
+			t.Fatal(err)
+		}
+
+		me, err := recoverMatchingEngine(filepath.Join(dir, "snapshots"), logPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(me.Snapshot(), expectedState(len(orders)-1)) {
+			t.Error("expected the recovered engine to have every order but the torn one")
+		}
+	})
+
+	t.Run("refuses a log that is behind the snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		snapshotDir := filepath.Join(dir, "snapshots")
+		snapshotted, err := matching.Restore(nil, expectedState(len(orders)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := matching.WriteSnapshotFile(snapshotDir, snapshotted.InputSequence(), snapshotted.Snapshot()); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := recoverMatchingEngine(snapshotDir, filepath.Join(dir, "missing.log")); err == nil {
+			t.Error("expected recovery to fail")
+		}
+	})
+}
+
+// fakeEventClient records what is added to the event service.
+type fakeEventClient struct {
+	proto.EventServiceClient
+	added []*proto.AddRequest
+}
+
+func (c *fakeEventClient) Add(ctx context.Context, req *proto.AddRequest, opts ...grpc.CallOption) (*proto.AddResponse, error) {
+	c.added = append(c.added, req)
+	return &proto.AddResponse{}, nil
+}
+
+func TestPublishSnapshot(t *testing.T) {
+	client := &fakeEventClient{}
+	taken := publishSnapshot(client)
+	taken(42, "snapshots/snapshot-00000000000000000042.bin", nil)
+	taken(43, "", errors.New("disk full"))
+
+	if len(client.added) != 1 {
+		t.Fatalf("expected one snapshot to be published, got %d", len(client.added))
+	}
+	req := client.added[0]
+	topic := &streaming.Topic{Name: "snapshot", Schema: map[string]interface{}{"snapshot_id": "string", "data": "string"}}
+	if req.Topic != topic.Name || len(req.Payloads) != 1 {
+		t.Fatalf("expected one payload on the snapshot topic, got %+v", req)
+	}
+	if err := topic.Validate(req.Payloads[0]); err != nil {
+		t.Errorf("expected the payload to match the topic schema, got %v", err)
+	}
+	if !strings.Contains(string(req.Payloads[0]), `"snapshot_id":"42"`) {
+		t.Errorf("expected snapshot ID 42, got %s", req.Payloads[0])
 	}
 }
@@ -3,16 +3,74 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"matching_engine/pkg/matching"
+	"matching_engine/pkg/streaming"
 	"matching_engine/pkg/streaming/proto"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func startMatchingEngine() {
+// recoverMatchingEngine rebuilds the engine's state after a restart. It
+// restores the newest valid snapshot in snapshotDir, or starts empty, and
+// replays the orders in the event log that the snapshot has not seen. Orders
+// are counted the way the engine numbers its input, so the snapshot's input
+// sequence is the number of logged orders to skip. That holds because the
+// event bus refuses an order it has no room for rather than drop one, so the
+// engine polls every logged order exactly once, in log order.
+func recoverMatchingEngine(snapshotDir, eventLogPath string) (*matching.MatchingEngine, error) {
+	me, err := matching.RestoreLatest(nil, snapshotDir)
+	if errors.Is(err, matching.ErrNoSnapshot) {
+		me = matching.NewMatchingEngine(nil)
+	} else if err != nil {
+		return nil, err
+	}
+
+	skip := me.InputSequence()
+	var seen uint64
+	err = streaming.ReadEvents(eventLogPath, func(event *streaming.Event) error {
+		if event.Topic != "order" {
+			return nil
+		}
+		order, err := decodeOrder(event.Payload)
+		if err != nil {
+			return nil
+		}
+		if seen++; seen > skip {
+			me.Replay(matching.Event{Order: order})
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if seen < skip {
+		return nil, fmt.Errorf("event log ends at order %d, before the snapshot at %d", seen, skip)
+	}
+	return me, nil
+}
+
+func decodeOrder(payload []byte) (*matching.Order, error) {
+	var order matching.Order
+	if err := json.Unmarshal(payload, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// startMatchingEngine feeds the engine orders from the "order" topic. It must
+// be given an engine that has already recovered from the event log, before
+// the streaming server accepts new events.
+func startMatchingEngine(me *matching.MatchingEngine) {
 	conn, err := grpc.Dial("localhost:8081", grpc.WithInsecure(), grpc.WithBlock())
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
@@ -20,7 +78,21 @@ func startMatchingEngine() {
 	defer conn.Close()
 	client := proto.NewEventServiceClient(conn)
 
-	me := matching.NewMatchingEngine(nil)
+	// The engine is not safe for concurrent use; mu serialises the order
+	// consumer against read-only queries from the HTTP handlers.
+	var mu sync.Mutex
+
+	// Snapshots bound how much of the event log a restart has to replay.
+	// The engine gets no clock ticks here, so they are taken by input count.
+	err = me.ScheduleSnapshots(matching.SnapshotPolicy{
+		Dir:    snapshotDir,
+		Inputs: 10000,
+		Retain: 5,
+		Taken:  publishSnapshot(client),
+	})
+	if err != nil {
+		log.Fatalf("failed to schedule snapshots: %v", err)
+	}
 
 	go func() {
 		stream, err := client.Poll(context.Background(), &proto.PollRequest{Topic: "order", MaxEvents: 100})
@@ -33,12 +105,14 @@ func startMatchingEngine() {
 				log.Fatalf("failed to receive: %v", err)
 			}
 			for _, event := range resp.Events {
-				var order matching.Order
-				if err := json.Unmarshal(event.Payload, &order); err != nil {
+				order, err := decodeOrder(event.Payload)
+				if err != nil {
 					log.Printf("failed to unmarshal order: %v", err)
 					continue
 				}
-				me.PlaceOrder(&order)
+				mu.Lock()
+				me.PlaceOrder(order)
+				mu.Unlock()
 			}
 		}
 	}()
@@ -62,7 +136,11 @@ func startMatchingEngine() {
 
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
-		if _, err := client.Add(ctx, &proto.AddRequest{Topic: "order", Payloads: payloads}); err != nil {
+		if _, err := client.Add(ctx, &proto.AddRequest{Topic: "order", Payloads: payloads}); status.Code(err) == codes.ResourceExhausted {
+			// The engine is behind; nothing was logged, so the orders can be sent again.
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -70,6 +148,64 @@ func startMatchingEngine() {
 		w.WriteHeader(http.StatusAccepted)
 	})
 
+	http.HandleFunc("/depth", depthHandler(me, &mu))
+
 	log.Println("Matching engine server started on :8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
+
+// publishSnapshot announces every snapshot written on the "snapshot" topic,
+// with its input sequence as the snapshot ID and its path as the data.
+func publishSnapshot(client proto.EventServiceClient) func(sequence uint64, path string, err error) {
+	return func(sequence uint64, path string, err error) {
+		if err != nil {
+			log.Printf("failed to write snapshot at %d: %v", sequence, err)
+			return
+		}
+		payload, err := json.Marshal(map[string]string{
+			"snapshot_id": strconv.FormatUint(sequence, 10),
+			"data":        path,
+		})
+		if err != nil {
+			log.Printf("failed to encode snapshot %d: %v", sequence, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if _, err := client.Add(ctx, &proto.AddRequest{Topic: "snapshot", Payloads: [][]byte{payload}}); err != nil {
+			log.Printf("failed to publish snapshot %d: %v", sequence, err)
+		}
+	}
+}
+
+// depthHandler serves the aggregated L2 book for ?symbol=, limited to the top
+// ?levels= price levels per side (all levels if omitted).
+func depthHandler(me *matching.MatchingEngine, mu *sync.Mutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		levels := 0
+		if v := r.URL.Query().Get("levels"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid levels", http.StatusBadRequest)
+				return
+			}
+			levels = n
+		}
+
+		mu.Lock()
+		orderBook := me.GetInstrumentOrderBook(r.URL.Query().Get("symbol"))
+		if orderBook == nil {
+			mu.Unlock()
+			http.Error(w, "unknown symbol", http.StatusNotFound)
+			return
+		}
+		depth := orderBook.Depth(levels)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(depth); err != nil {
+			log.Printf("failed to encode depth: %v", err)
+		}
+	}
+}
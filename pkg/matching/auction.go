@@ -0,0 +1,220 @@
+package matching
+
+import (
+	"sort"
+)
+
+// IndicativeAuctionPrice is published during a call auction whenever the
+// price and volume the auction would uncross at change. Imbalance is the
+// quantity that would be left over at that price: positive on the buy side,
+// negative on the sell side. A zero Price means the book does not cross.
+type IndicativeAuctionPrice struct {
+	Price     int64
+	Quantity  int
+	Imbalance int
+}
+
+// AuctionUncrossed is published when an auction ends, after its trades. The
+// instrument then trades continuously.
+type AuctionUncrossed struct {
+	Price    int64
+	Quantity int
+}
+
+func (IndicativeAuctionPrice) executionReport() {}
+func (AuctionUncrossed) executionReport()       {}
+
+type auctionLevel struct {
+	price    int64
+	quantity int
+}
+
+// auctionLevels aggregates the open quantity, hidden reserves included, of
+// one side of the book by price, best price first. All-or-none orders take
+// no part in auctions.
+func (in *instrument) auctionLevels(side string) []auctionLevel {
+	var levels []auctionLevel
+	in.orderBook.walk(side, func(order *BookOrder) bool {
+		if order.AllOrNone {
+			return true
+		}
+		if n := len(levels); n > 0 && levels[n-1].price == order.Price {
+			levels[n-1].quantity += order.openQuantity()
+		} else {
+			levels = append(levels, auctionLevel{price: order.Price, quantity: order.openQuantity()})
+		}
+		return true
+	})
+	return levels
+}
+
+// equilibrium works out the price the auction uncrosses at. Of all the limit
+// prices on the book it picks the one that executes the most volume, then the
+// one that leaves the smallest imbalance, then the one closest to the
+// reference price; any remaining tie goes to the lowest price. It returns a
+// zero price and volume if the book does not cross.
+func (in *instrument) equilibrium() IndicativeAuctionPrice {
+	bids := in.auctionLevels("buy")  // Highest price first.
+	asks := in.auctionLevels("sell") // Lowest price first.
+
+	prices := make([]int64, 0, len(bids)+len(asks))
+	buyVolume := 0
+	for _, level := range bids {
+		prices = append(prices, level.price)
+		buyVolume += level.quantity
+	}
+	for _, level := range asks {
+		prices = append(prices, level.price)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] < prices[j] })
+
+	reference := in.referencePrice()
+	var best IndicativeAuctionPrice
+	sellVolume := 0
+	bid, ask := len(bids)-1, 0
+	for i, price := range prices {
+		if i > 0 && price == prices[i-1] {
+			continue
+		}
+		// Walking up in price, bids below it drop out and asks at or
+		// below it join in.
+		for bid >= 0 && bids[bid].price < price {
+			buyVolume -= bids[bid].quantity
+			bid--
+		}
+		for ask < len(asks) && asks[ask].price <= price {
+			sellVolume += asks[ask].quantity
+			ask++
+		}
+
+		candidate := IndicativeAuctionPrice{
+			Price:     price,
+			Quantity:  min(buyVolume, sellVolume),
+			Imbalance: buyVolume - sellVolume,
+		}
+		if candidate.Quantity > 0 && betterEquilibrium(candidate, best, reference) {
+			best = candidate
+		}
+	}
+	return best
+}
+
+func betterEquilibrium(candidate, best IndicativeAuctionPrice, reference int64) bool {
+	if candidate.Quantity != best.Quantity {
+		return candidate.Quantity > best.Quantity
+	}
+	if a, b := abs(candidate.Imbalance), abs(best.Imbalance); a != b {
+		return a < b
+	}
+	if reference > 0 {
+		return abs64(candidate.Price-reference) < abs64(best.Price-reference)
+	}
+	return false
+}
+
+// publishIndicative publishes the auction's indicative price and volume if
+// they have changed since the last update.
+func (in *instrument) publishIndicative() {
+	if in.phase != PhaseAuction {
+		return
+	}
+	indicative := in.equilibrium()
+	if indicative == in.indicative {
+		return
+	}
+	in.indicative = indicative
+	in.publish(indicative)
+}
+
+// uncross ends the auction: every order that can trade at the equilibrium
+// price does so at that price, in price-time priority on each side, and the
+// instrument returns to continuous trading.
+func (in *instrument) uncross() {
+	result := in.equilibrium()
+
+	var buys, sells []*BookOrder
+	if result.Quantity > 0 {
+		in.orderBook.walk("buy", func(order *BookOrder) bool {
+			if order.Price < result.Price {
+				return false
+			}
+			if !order.AllOrNone {
+				buys = append(buys, order)
+			}
+			return true
+		})
+		in.orderBook.walk("sell", func(order *BookOrder) bool {
+			if order.Price > result.Price {
+				return false
+			}
+			if !order.AllOrNone {
+				sells = append(sells, order)
+			}
+			return true
+		})
+	}
+
+	for remaining := result.Quantity; remaining > 0; {
+		buy, sell := buys[0], sells[0]
+		quantity := min(buy.openQuantity(), sell.openQuantity(), remaining)
+		in.auctionFill(buy, quantity)
+		in.auctionFill(sell, quantity)
+		remaining -= quantity
+
+		// An auction trade has no aggressor; the buy order is reported
+		// as the taker.
+		in.lastTradePrice = result.Price
+		in.recordTrade(result.Price, quantity)
+		trade := Trade{
+			TakerOrderID: buy.ID,
+			MakerOrderID: sell.ID,
+			Price:        result.Price,
+			Quantity:     quantity,
+			Auction:      true,
+		}
+		in.chargeFees(&trade, buy.OrdererID, sell.OrdererID)
+		in.publish(trade)
+		in.reportFill(buy.ID, buy.OrdererID, buy.Side, result.Price, quantity, buy.openQuantity())
+		in.reportFill(sell.ID, sell.OrdererID, sell.Side, result.Price, quantity, sell.openQuantity())
+
+		if buy.openQuantity() == 0 {
+			buys = buys[1:]
+		}
+		if sell.openQuantity() == 0 {
+			sells = sells[1:]
+		}
+	}
+
+	in.phase = PhaseContinuous
+	in.phaseEndsAt = 0
+	in.indicative = IndicativeAuctionPrice{}
+	in.publish(AuctionUncrossed{Price: result.Price, Quantity: result.Quantity})
+	if result.Quantity > 0 {
+		in.ratchetTrailingStops(result.Price)
+	}
+	in.triggerStopLossOrders()
+}
+
+// auctionFill takes quantity off a resting order, working through an
+// iceberg's reserve one peak at a time.
+func (in *instrument) auctionFill(order *BookOrder, quantity int) {
+	for quantity > 0 {
+		take := min(order.Quantity, quantity)
+		in.orderBook.Fill(order.ID, take)
+		quantity -= take
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func abs64(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
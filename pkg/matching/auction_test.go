@@ -0,0 +1,185 @@
+package matching
+
+import (
+	"testing"
+)
+
+func TestMatchingEngine_Equilibrium(t *testing.T) {
+	newAuction := func() *MatchingEngine {
+		return NewMatchingEngineWithConfig(nil, &OrderBookConfig{MinTickSize: 1, OpeningAuction: true})
+	}
+
+	t.Run("should maximise the executed volume", func(t *testing.T) {
+		me := newAuction()
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 101 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 99 * PricePrecision, Quantity: 8})
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 4})
+
+		expected := IndicativeAuctionPrice{Price: 100 * PricePrecision, Quantity: 12, Imbalance: 3}
+		if result := me.instruments[DefaultSymbol].equilibrium(); result != expected {
+			t.Errorf("Expected %+v, got %+v", expected, result)
+		}
+	})
+
+	t.Run("should minimise the imbalance between equal volumes", func(t *testing.T) {
+		me := newAuction()
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 101 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+
+		expected := IndicativeAuctionPrice{Price: 101 * PricePrecision, Quantity: 10, Imbalance: 0}
+		if result := me.instruments[DefaultSymbol].equilibrium(); result != expected {
+			t.Errorf("Expected %+v, got %+v", expected, result)
+		}
+	})
+
+	t.Run("should break remaining ties on the reference price", func(t *testing.T) {
+		me := newAuction()
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 102 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+
+		in := me.instruments[DefaultSymbol]
+		if result := in.equilibrium(); result.Price != 100*PricePrecision {
+			t.Errorf("Expected the lowest price without a reference, got %d", result.Price)
+		}
+		in.lastTradePrice = 103 * PricePrecision
+		if result := in.equilibrium(); result.Price != 102*PricePrecision {
+			t.Errorf("Expected the price closest to the reference, got %d", result.Price)
+		}
+	})
+
+	t.Run("should report no price when the book does not cross", func(t *testing.T) {
+		me := newAuction()
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+
+		if result := me.instruments[DefaultSymbol].equilibrium(); result != (IndicativeAuctionPrice{}) {
+			t.Errorf("Expected no equilibrium, got %+v", result)
+		}
+	})
+}
+
+func TestMatchingEngine_CallAuction(t *testing.T) {
+	t.Run("should collect orders and uncross at a single price", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: 1, OpeningAuction: true})
+		if phase := me.TradingPhase(DefaultSymbol); phase != PhaseAuction {
+			t.Fatalf("Expected to open in an auction, got %s", phase)
+		}
+
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 101 * PricePrecision, Quantity: 10})
+		if outputBuffer.Size() != 0 {
+			t.Fatalf("Expected no indicative price before the book crosses, got %d events", outputBuffer.Size())
+		}
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 99 * PricePrecision, Quantity: 6})
+		event, _ := outputBuffer.Pop()
+		expected := IndicativeAuctionPrice{Price: 99 * PricePrecision, Quantity: 6, Imbalance: 4}
+		if indicative, ok := event.Report.(IndicativeAuctionPrice); !ok || indicative != expected {
+			t.Fatalf("Expected indicative %+v, but got %v", expected, event.Report)
+		}
+		if me.GetOrderBook().BestBid().ID != 1 || me.GetOrderBook().BestAsk().ID != 2 {
+			t.Fatal("Expected both orders to rest without matching")
+		}
+
+		me.PlaceOrder(&Order{ID: 3, Type: "market", Side: "buy", Quantity: 5})
+		event, _ = outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectNotAllowedInAuction {
+			t.Errorf("Expected the market order to be rejected, but got %v", event.Report)
+		}
+
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 6})
+		event, _ = outputBuffer.Pop()
+		expected = IndicativeAuctionPrice{Price: 100 * PricePrecision, Quantity: 10, Imbalance: -2}
+		if indicative, ok := event.Report.(IndicativeAuctionPrice); !ok || indicative != expected {
+			t.Fatalf("Expected indicative %+v, but got %v", expected, event.Report)
+		}
+
+		me.ChangePhase(&PhaseChange{Phase: PhaseContinuous})
+		for _, maker := range []int{2, 4} {
+			event, _ = outputBuffer.Pop()
+			trade, ok := event.Report.(Trade)
+			if !ok || !trade.Auction || trade.TakerOrderID != 1 || trade.MakerOrderID != maker || trade.Price != 100*PricePrecision {
+				t.Errorf("Expected an auction trade between 1 and %d at 100, but got %v", maker, event.Report)
+			}
+		}
+		event, _ = outputBuffer.Pop()
+		if uncrossed, ok := event.Report.(AuctionUncrossed); !ok || uncrossed.Quantity != 10 || uncrossed.Price != 100*PricePrecision {
+			t.Errorf("Expected the auction to uncross 10 at 100, but got %v", event.Report)
+		}
+		if phase := me.TradingPhase(DefaultSymbol); phase != PhaseContinuous {
+			t.Errorf("Expected continuous trading after the uncross, got %s", phase)
+		}
+		if best := me.GetOrderBook().BestAsk(); best == nil || best.ID != 4 || best.Quantity != 2 {
+			t.Errorf("Expected 2 of order 4 left on the book, got %+v", best)
+		}
+		if best := me.GetOrderBook().BestBid(); best != nil {
+			t.Errorf("Expected no bids left, got %+v", best)
+		}
+	})
+
+	t.Run("should trade an iceberg's reserve in the uncross", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: 1, OpeningAuction: true})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 30, DisplayQuantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 25})
+		me.ChangePhase(&PhaseChange{Phase: PhaseContinuous})
+
+		outputBuffer.Pop() // indicative price
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.Quantity != 25 {
+			t.Errorf("Expected a single trade of 25, but got %v", event.Report)
+		}
+		if open := me.GetOrderBook().GetOrder(1).openQuantity(); open != 5 {
+			t.Errorf("Expected 5 of the iceberg left, got %d", open)
+		}
+	})
+
+	t.Run("should reopen through an auction after a volatility halt", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{
+			MinTickSize:           1,
+			VolatilityBasisPoints: 500,
+			VolatilityWindow:      1000,
+			HaltDuration:          5000,
+			AuctionDuration:       2000,
+		})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 106 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 3, Type: "market", Side: "buy", Quantity: 2})
+		for outputBuffer.Size() > 0 {
+			outputBuffer.Pop()
+		}
+
+		me.AdvanceClock(&ClockTick{Time: 5000})
+		event, _ := outputBuffer.Pop()
+		if started, ok := event.Report.(AuctionStarted); !ok || started.UncrossAt != 7000 {
+			t.Fatalf("Expected a reopening auction until 7000, but got %v", event.Report)
+		}
+
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "buy", Price: 103 * PricePrecision, Quantity: 3})
+		me.PlaceOrder(&Order{ID: 5, Type: "limit", Side: "sell", Price: 103 * PricePrecision, Quantity: 3})
+		outputBuffer.Pop() // indicative price
+
+		me.AdvanceClock(&ClockTick{Time: 7000})
+		event, _ = outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || !trade.Auction || trade.Quantity != 3 {
+			t.Errorf("Expected an auction trade of 3, but got %v", event.Report)
+		}
+		event, _ = outputBuffer.Pop()
+		if _, ok := event.Report.(AuctionUncrossed); !ok {
+			t.Errorf("Expected the auction to uncross, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should reject an unknown phase", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.ChangePhase(&PhaseChange{Phase: "closed"})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(PhaseChangeRejected); !ok || rejected.Reason != RejectInvalidPhase {
+			t.Errorf("Expected an invalid phase rejection, but got %v", event.Report)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package matching
+
+// Reference prices that price bands are centred on.
+const (
+	PriceReferenceLastTrade = "last-trade"
+	PriceReferenceVWAP      = "vwap"
+)
+
+type tradePoint struct {
+	time     int64
+	price    int64
+	quantity int
+}
+
+// A tradeWindow keeps the trades of the last length nanoseconds of engine
+// time, with running totals for the VWAP and monotonic queues for the lowest
+// and highest price, so every query is O(1).
+type tradeWindow struct {
+	length   int64
+	trades   []tradePoint
+	notional int64
+	quantity int64
+	lows     []tradePoint // Increasing prices; the front is the window low.
+	highs    []tradePoint // Decreasing prices; the front is the window high.
+}
+
+func (w *tradeWindow) add(now, price int64, quantity int) {
+	w.expire(now)
+	trade := tradePoint{time: now, price: price, quantity: quantity}
+	w.trades = append(w.trades, trade)
+	w.notional += price * int64(quantity)
+	w.quantity += int64(quantity)
+	for len(w.lows) > 0 && w.lows[len(w.lows)-1].price >= price {
+		w.lows = w.lows[:len(w.lows)-1]
+	}
+	w.lows = append(w.lows, trade)
+	for len(w.highs) > 0 && w.highs[len(w.highs)-1].price <= price {
+		w.highs = w.highs[:len(w.highs)-1]
+	}
+	w.highs = append(w.highs, trade)
+}
+
+// expire drops the trades that are older than the window at now.
+func (w *tradeWindow) expire(now int64) {
+	cutoff := now - w.length
+	for len(w.trades) > 0 && w.trades[0].time < cutoff {
+		w.notional -= w.trades[0].price * int64(w.trades[0].quantity)
+		w.quantity -= int64(w.trades[0].quantity)
+		w.trades = w.trades[1:]
+	}
+	for len(w.lows) > 0 && w.lows[0].time < cutoff {
+		w.lows = w.lows[1:]
+	}
+	for len(w.highs) > 0 && w.highs[0].time < cutoff {
+		w.highs = w.highs[1:]
+	}
+}
+
+func (w *tradeWindow) reset() {
+	*w = tradeWindow{length: w.length}
+}
+
+func (w *tradeWindow) vwap() int64 {
+	if w.quantity == 0 {
+		return 0
+	}
+	return w.notional / w.quantity
+}
+
+func (w *tradeWindow) low() int64  { return w.lows[0].price }
+func (w *tradeWindow) high() int64 { return w.highs[0].price }
+
+// recordTrade feeds a trade into the rolling windows the instrument keeps.
+func (in *instrument) recordTrade(price int64, quantity int) {
+	if in.vwapWindow != nil {
+		in.vwapWindow.add(in.engine.now, price, quantity)
+	}
+	if in.volatilityWindow != nil {
+		in.volatilityWindow.add(in.engine.now, price, quantity)
+	}
+}
+
+// referencePrice is the price the bands are centred on, or 0 before the
+// instrument has traded.
+func (in *instrument) referencePrice() int64 {
+	if in.vwapWindow != nil {
+		in.vwapWindow.expire(in.engine.now)
+		if vwap := in.vwapWindow.vwap(); vwap > 0 {
+			return vwap
+		}
+	}
+	return in.lastTradePrice
+}
+
+// priceBand returns the prices orders may be placed and matched at. It
+// reports false if bands are off or there is no reference price yet.
+func (in *instrument) priceBand() (low, high int64, ok bool) {
+	bps := in.orderBook.config.PriceBandBasisPoints
+	if bps == 0 {
+		return 0, 0, false
+	}
+	reference := in.referencePrice()
+	if reference == 0 {
+		return 0, 0, false
+	}
+	width := reference * bps / 10000
+	return reference - width, reference + width, true
+}
+
+func (in *instrument) withinPriceBand(price int64) bool {
+	low, high, ok := in.priceBand()
+	return !ok || (price >= low && price <= high)
+}
+
+// checkVolatility halts the instrument if prices within the volatility window
+// have moved further apart than the configured threshold. It reports whether
+// it did.
+func (in *instrument) checkVolatility() bool {
+	w := in.volatilityWindow
+	if w == nil {
+		return false
+	}
+	w.expire(in.engine.now)
+	if len(w.trades) == 0 {
+		return false
+	}
+	low, high := w.low(), w.high()
+	if (high-low)*10000 <= low*in.orderBook.config.VolatilityBasisPoints {
+		return false
+	}
+
+	w.reset()
+	in.halt(TradingHalted{
+		Reason:   HaltReasonVolatility,
+		Low:      low,
+		High:     high,
+		ResumeAt: in.engine.now + in.orderBook.config.HaltDuration,
+	})
+	return true
+}
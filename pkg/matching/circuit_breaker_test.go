@@ -0,0 +1,172 @@
+package matching
+
+import (
+	"testing"
+)
+
+func TestTradeWindow(t *testing.T) {
+	w := &tradeWindow{length: 10}
+	w.add(0, 100, 1)
+	w.add(5, 120, 3)
+	w.add(8, 90, 1)
+
+	t.Run("should track the low, high and VWAP", func(t *testing.T) {
+		if w.low() != 90 || w.high() != 120 {
+			t.Errorf("Expected low 90 and high 120, got %d and %d", w.low(), w.high())
+		}
+		if vwap := w.vwap(); vwap != (100+360+90)/5 {
+			t.Errorf("Expected VWAP %d, got %d", (100+360+90)/5, vwap)
+		}
+	})
+
+	t.Run("should forget trades that leave the window", func(t *testing.T) {
+		w.expire(16)
+		if len(w.trades) != 1 || w.low() != 90 || w.high() != 90 {
+			t.Errorf("Expected only the trade at 90 to remain, got %+v", w.trades)
+		}
+		if vwap := w.vwap(); vwap != 90 {
+			t.Errorf("Expected VWAP 90, got %d", vwap)
+		}
+	})
+}
+
+func TestMatchingEngine_PriceBands(t *testing.T) {
+	newEngine := func(config *OrderBookConfig) (*MatchingEngine, *testOutput) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, config)
+		// Establish a last trade at 100.
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 1})
+		outputBuffer.Pop()
+		return me, outputBuffer
+	}
+
+	t.Run("should reject orders outside the band", func(t *testing.T) {
+		me, outputBuffer := newEngine(&OrderBookConfig{MinTickSize: 1, PriceBandBasisPoints: 500})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "buy", Price: 94 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "sell", Price: 105 * PricePrecision, Quantity: 1})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.OrderID != 3 || rejected.Reason != RejectPriceOutsideBand {
+			t.Errorf("Expected order 3 to be outside the band, but got %v", event.Report)
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected order 4 on the band edge to be accepted, got %d more events", outputBuffer.Size())
+		}
+	})
+
+	t.Run("should stop a market order at the band edge", func(t *testing.T) {
+		me, outputBuffer := newEngine(&OrderBookConfig{MinTickSize: 1, PriceBandBasisPoints: 500})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 104 * PricePrecision, Quantity: 5})
+		me.GetOrderBook().AddOrder(&BookOrder{ID: 4, Side: "sell", Price: 110 * PricePrecision, Quantity: 5})
+
+		me.PlaceOrder(&Order{ID: 5, Type: "market", Side: "buy", Quantity: 10})
+
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 3 {
+			t.Errorf("Expected a trade against order 3, but got %v", event.Report)
+		}
+		event, _ = outputBuffer.Pop()
+		if cancelled, ok := event.Report.(OrderCancelled); !ok || cancelled.Quantity != 5 || cancelled.Reason != CancelReasonPriceBand {
+			t.Errorf("Expected the remaining 5 to be cancelled at the band, but got %v", event.Report)
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no trade beyond the band, got %d more events", outputBuffer.Size())
+		}
+		if best := me.GetOrderBook().BestAsk(); best == nil || best.ID != 4 {
+			t.Errorf("Expected order 4 to stay on the book, got %+v", best)
+		}
+	})
+
+	t.Run("should report whichever of the band and the protection limit is nearer", func(t *testing.T) {
+		for _, tt := range []struct {
+			ticks    int
+			expected string
+		}{
+			{1, CancelReasonProtectionLimit},
+			{20, CancelReasonPriceBand},
+		} {
+			me, outputBuffer := newEngine(&OrderBookConfig{MinTickSize: PricePrecision, PriceBandBasisPoints: 500})
+			me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 5})
+			me.GetOrderBook().AddOrder(&BookOrder{ID: 4, Side: "sell", Price: 110 * PricePrecision, Quantity: 5})
+
+			me.PlaceOrder(&Order{ID: 5, Type: "market", Side: "buy", Quantity: 10, ProtectionTicks: tt.ticks})
+
+			outputBuffer.Pop()
+			event, _ := outputBuffer.Pop()
+			if cancelled, ok := event.Report.(OrderCancelled); !ok || cancelled.Reason != tt.expected {
+				t.Errorf("Expected a %s cancellation with %d ticks of protection, but got %v", tt.expected, tt.ticks, event.Report)
+			}
+		}
+	})
+
+	t.Run("should centre the band on the VWAP", func(t *testing.T) {
+		me, outputBuffer := newEngine(&OrderBookConfig{MinTickSize: 1, PriceBandBasisPoints: 500, PriceBandReference: PriceReferenceVWAP, VWAPWindow: 1000})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 104 * PricePrecision, Quantity: 3})
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "buy", Price: 104 * PricePrecision, Quantity: 3})
+		outputBuffer.Pop()
+
+		// VWAP is 103 while the last trade is 104, so 108.5 is out of band.
+		me.PlaceOrder(&Order{ID: 5, Type: "limit", Side: "sell", Price: 1085000, Quantity: 1})
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectPriceOutsideBand {
+			t.Errorf("Expected a band rejection around the VWAP, but got %v", event.Report)
+		}
+	})
+}
+
+func TestMatchingEngine_VolatilityHalt(t *testing.T) {
+	config := &OrderBookConfig{
+		MinTickSize:           1,
+		VolatilityBasisPoints: 500,
+		VolatilityWindow:      1000,
+		HaltDuration:          5000,
+	}
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngineWithConfig(outputBuffer.buffer, config)
+	me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 1})
+	me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 106 * PricePrecision, Quantity: 1})
+
+	t.Run("should halt when the price moves too far within the window", func(t *testing.T) {
+		me.PlaceOrder(&Order{ID: 3, Type: "market", Side: "buy", Quantity: 2})
+
+		outputBuffer.Pop()
+		outputBuffer.Pop()
+		event, _ := outputBuffer.Pop()
+		halted, ok := event.Report.(TradingHalted)
+		if !ok || halted.Reason != HaltReasonVolatility || halted.Low != 100*PricePrecision || halted.High != 106*PricePrecision {
+			t.Fatalf("Expected a volatility halt between 100 and 106, but got %v", event.Report)
+		}
+		if halted.ResumeAt != 5000 {
+			t.Errorf("Expected trading to resume at 5000, got %d", halted.ResumeAt)
+		}
+	})
+
+	t.Run("should reject new orders while halted", func(t *testing.T) {
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 1})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectInstrumentHalted {
+			t.Errorf("Expected a halted rejection, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should resume once the halt has run its course", func(t *testing.T) {
+		me.AdvanceClock(&ClockTick{Time: 4999})
+		if outputBuffer.Size() != 0 {
+			t.Fatalf("Expected the halt to still be on, got %d events", outputBuffer.Size())
+		}
+
+		me.AdvanceClock(&ClockTick{Time: 5000})
+		event, _ := outputBuffer.Pop()
+		if _, ok := event.Report.(TradingResumed); !ok {
+			t.Fatalf("Expected trading to resume, but got %v", event.Report)
+		}
+
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 1})
+		if outputBuffer.Size() != 0 {
+			event, _ := outputBuffer.Pop()
+			t.Errorf("Expected the order to be accepted, but got %v", event.Report)
+		}
+	})
+}
@@ -5,8 +5,21 @@ import (
 )
 
 type Event struct {
-	Order *Order
-	Data  interface{}
+	Order  *Order
+	Data   interface{}     // Input command: *CancelRequest, *AmendRequest, *ClockTick or *PhaseChange.
+	Report ExecutionReport // Output message published by the engine.
+	Symbol string          // Instrument an output event belongs to.
+
+	// Sequence is the engine sequence number of the input command an output
+	// event was published for. OutputSequence numbers the output events
+	// themselves. Both start at 1. The engine never waits for the output
+	// buffer: an event published while it is full is dropped, and shows up
+	// as a gap in OutputSequence.
+	Sequence       uint64
+	OutputSequence uint64
+	// Replayed marks output events published again while the engine
+	// replays its input log after a restart.
+	Replayed bool
 }
 
 // A CacheLinePad is used to pad structs to avoid false sharing.
@@ -0,0 +1,103 @@
+package matching
+
+// An ExecutionReport is a message the engine publishes on its output buffer.
+// The set is closed: only the report types declared in this package implement
+// it, so consumers can type-switch over every case. Taken together, the
+// reports for an order describe its whole lifecycle.
+type ExecutionReport interface {
+	executionReport()
+}
+
+// OrderAccepted is published once a new order has passed validation and
+// entered the engine, before it trades or rests.
+type OrderAccepted struct {
+	OrderID   int
+	OrdererID int
+	Type      string
+	Side      string
+	Price     int64
+	Quantity  int
+}
+
+// OrderPartiallyFilled is published for each side of a trade that leaves the
+// order with open quantity. Price and Quantity describe that trade only.
+type OrderPartiallyFilled struct {
+	OrderID           int
+	OrdererID         int
+	Side              string
+	Price             int64
+	Quantity          int
+	RemainingQuantity int
+}
+
+// OrderFilled is published when a trade completes an order. Price and
+// Quantity describe the final trade.
+type OrderFilled struct {
+	OrderID   int
+	OrdererID int
+	Side      string
+	Price     int64
+	Quantity  int
+}
+
+// BookSnapshot lists the resting orders of one instrument in priority order.
+// Quantities are displayed quantities; iceberg reserves are not shown.
+type BookSnapshot struct {
+	Bids []SnapshotOrder
+	Asks []SnapshotOrder
+}
+
+// A SnapshotOrder is one resting order in a BookSnapshot.
+type SnapshotOrder struct {
+	OrderID  int
+	Price    int64
+	Quantity int
+}
+
+func (OrderAccepted) executionReport()        {}
+func (OrderRejected) executionReport()        {}
+func (OrderPriceAdjusted) executionReport()   {}
+func (OrderPartiallyFilled) executionReport() {}
+func (OrderFilled) executionReport()          {}
+func (Trade) executionReport()                {}
+func (OrderCancelled) executionReport()       {}
+func (CancelRejected) executionReport()       {}
+func (OrderReplaced) executionReport()        {}
+func (AmendRejected) executionReport()        {}
+func (OrderExpired) executionReport()         {}
+func (SelfTradePrevented) executionReport()   {}
+func (TrailingStopUpdated) executionReport()  {}
+func (BookSnapshot) executionReport()         {}
+
+// reportFill publishes the fill report for one side of a trade.
+func (in *instrument) reportFill(orderID, ordererID int, side string, price int64, quantity, remaining int) {
+	if remaining > 0 {
+		in.publish(OrderPartiallyFilled{
+			OrderID:           orderID,
+			OrdererID:         ordererID,
+			Side:              side,
+			Price:             price,
+			Quantity:          quantity,
+			RemainingQuantity: remaining,
+		})
+		return
+	}
+	in.publish(OrderFilled{
+		OrderID:   orderID,
+		OrdererID: ordererID,
+		Side:      side,
+		Price:     price,
+		Quantity:  quantity,
+	})
+}
+
+func (in *instrument) accept(order *Order) {
+	in.publish(OrderAccepted{
+		OrderID:   order.ID,
+		OrdererID: order.OrdererID,
+		Type:      order.Type,
+		Side:      order.Side,
+		Price:     order.Price,
+		Quantity:  order.Quantity,
+	})
+}
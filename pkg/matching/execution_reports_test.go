@@ -0,0 +1,111 @@
+package matching
+
+import (
+	"fmt"
+	"testing"
+)
+
+func drainReports(buffer *RingBuffer) []ExecutionReport {
+	var reports []ExecutionReport
+	for {
+		event, ok := buffer.Pop()
+		if !ok {
+			return reports
+		}
+		reports = append(reports, event.Report)
+	}
+}
+
+func TestMatchingEngine_ExecutionReports(t *testing.T) {
+	t.Run("should report every step of an order's lifecycle", func(t *testing.T) {
+		outputBuffer := NewRingBuffer(1024)
+		me := NewMatchingEngine(outputBuffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 4})
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 8, Type: "market", Side: "buy", Quantity: 6})
+
+		price := int64(100 * PricePrecision)
+		expected := []ExecutionReport{
+			OrderAccepted{OrderID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: price, Quantity: 10},
+			OrderAccepted{OrderID: 2, OrdererID: 8, Type: "limit", Side: "buy", Price: price, Quantity: 4},
+			Trade{TakerOrderID: 2, MakerOrderID: 1, Price: price, Quantity: 4},
+			OrderFilled{OrderID: 2, OrdererID: 8, Side: "buy", Price: price, Quantity: 4},
+			OrderPartiallyFilled{OrderID: 1, OrdererID: 7, Side: "sell", Price: price, Quantity: 4, RemainingQuantity: 6},
+			OrderAccepted{OrderID: 3, OrdererID: 8, Type: "market", Side: "buy", Quantity: 6},
+			Trade{TakerOrderID: 3, MakerOrderID: 1, Price: price, Quantity: 6},
+			OrderFilled{OrderID: 3, OrdererID: 8, Side: "buy", Price: price, Quantity: 6},
+			OrderFilled{OrderID: 1, OrdererID: 7, Side: "sell", Price: price, Quantity: 6},
+		}
+		reports := drainReports(outputBuffer)
+		if fmt.Sprint(reports) != fmt.Sprint(expected) {
+			t.Errorf("Expected reports %v, got %v", expected, reports)
+		}
+	})
+
+	t.Run("should count iceberg reserve as open quantity", func(t *testing.T) {
+		outputBuffer := NewRingBuffer(1024)
+		me := NewMatchingEngine(outputBuffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 25, DisplayQuantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		reports := drainReports(outputBuffer)
+		partial, ok := reports[len(reports)-1].(OrderPartiallyFilled)
+		if !ok || partial.OrderID != 1 || partial.RemainingQuantity != 15 {
+			t.Errorf("Expected order 1 to be partially filled with 15 left, but got %v", reports[len(reports)-1])
+		}
+	})
+
+	t.Run("should not accept a rejected order", func(t *testing.T) {
+		outputBuffer := NewRingBuffer(1024)
+		me := NewMatchingEngine(outputBuffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		drainReports(outputBuffer)
+
+		me.PlaceOrder(&Order{ID: 2, Type: "post-only", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+		reports := drainReports(outputBuffer)
+		if len(reports) != 1 {
+			t.Fatalf("Expected a single report, got %v", reports)
+		}
+		if _, ok := reports[0].(OrderRejected); !ok {
+			t.Errorf("Expected a rejection, but got %v", reports[0])
+		}
+	})
+
+	t.Run("should accept a stop order when it is placed", func(t *testing.T) {
+		outputBuffer := NewRingBuffer(1024)
+		me := NewMatchingEngine(outputBuffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-loss", Side: "sell", Price: 98 * PricePrecision, Quantity: 5})
+
+		reports := drainReports(outputBuffer)
+		if len(reports) != 1 {
+			t.Fatalf("Expected a single report, got %v", reports)
+		}
+		if accepted, ok := reports[0].(OrderAccepted); !ok || accepted.OrderID != 1 {
+			t.Errorf("Expected order 1 to be accepted, but got %v", reports[0])
+		}
+	})
+
+	t.Run("should publish a typed book snapshot", func(t *testing.T) {
+		outputBuffer := NewRingBuffer(1024)
+		me := NewMatchingEngine(outputBuffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 3})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 7})
+		drainReports(outputBuffer)
+
+		me.TakeSnapshot()
+		event, _ := outputBuffer.Pop()
+		expected := BookSnapshot{
+			Bids: []SnapshotOrder{
+				{OrderID: 2, Price: 100 * PricePrecision, Quantity: 3},
+				{OrderID: 1, Price: 99 * PricePrecision, Quantity: 5},
+			},
+			Asks: []SnapshotOrder{
+				{OrderID: 3, Price: 101 * PricePrecision, Quantity: 7},
+			},
+		}
+		if fmt.Sprint(event.Report) != fmt.Sprint(expected) {
+			t.Errorf("Expected snapshot %v, got %v", expected, event.Report)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package matching
+
+import (
+	"container/heap"
+	"time"
+)
+
+// A ClockTick moves engine time forward. Expiries are driven only by ticks in
+// the input stream, never by reading the wall clock, so replaying the same
+// input produces the same expirations.
+type ClockTick struct {
+	Time int64 // Unix nanoseconds
+}
+
+// OrderExpired is published when a DAY or GTD order reaches its expiry.
+// Quantity is the open quantity that was removed.
+type OrderExpired struct {
+	OrderID   int
+	OrdererID int
+	Side      string
+	Price     int64
+	Quantity  int
+	ExpireAt  int64
+}
+
+type expiry struct {
+	orderID  int
+	expireAt int64
+	sequence uint64
+}
+
+// An expiryQueue implements heap.Interface and keeps the soonest expiry on
+// top. Entries for orders that have since filled or been cancelled are left in
+// place and skipped when they come due. Order IDs can be reused once an order
+// is gone, so an entry only applies to a live order with the same expiry.
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int { return len(q) }
+
+func (q expiryQueue) Less(i, j int) bool {
+	if q[i].expireAt == q[j].expireAt {
+		return q[i].sequence < q[j].sequence
+	}
+	return q[i].expireAt < q[j].expireAt
+}
+
+func (q expiryQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiry)) }
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	*q = old[0 : n-1]
+	return item
+}
+
+// endOfDay returns the first UTC midnight after now.
+func endOfDay(now int64) int64 {
+	day := int64(24 * time.Hour)
+	return (now/day + 1) * day
+}
+
+// validateExpiry checks an order's time in force and works out when a DAY
+// order expires. It returns the reject reason, or "" if the order is valid.
+func (in *instrument) validateExpiry(order *Order) string {
+	switch order.TimeInForce {
+	case "", "gtc":
+	case "day":
+		order.ExpireAt = endOfDay(in.engine.now)
+	case "gtd":
+		if order.ExpireAt <= in.engine.now {
+			return RejectInvalidExpiry
+		}
+	default:
+		return RejectInvalidExpiry
+	}
+	return ""
+}
+
+// expiresAt returns when an order has to leave the book, or 0 if it does not
+// expire.
+func expiresAt(order *Order) int64 {
+	if order.TimeInForce == "day" || order.TimeInForce == "gtd" {
+		return order.ExpireAt
+	}
+	return 0
+}
+
+// scheduleExpiry records when an accepted DAY or GTD order has to leave the
+// book. It must only be called once the order is accepted, as the entry
+// stays queued until it comes due.
+func (in *instrument) scheduleExpiry(order *Order) {
+	expireAt := expiresAt(order)
+	if expireAt == 0 {
+		return
+	}
+	in.expirySequence++
+	heap.Push(in.expiries, expiry{orderID: order.ID, expireAt: expireAt, sequence: in.expirySequence})
+}
+
+// expireOrders removes every order that is due at engine time now.
+func (in *instrument) expireOrders(now int64) {
+	for in.expiries.Len() > 0 && (*in.expiries)[0].expireAt <= now {
+		due := heap.Pop(in.expiries).(expiry)
+		in.expireOrder(due)
+	}
+}
+
+func (in *instrument) expireOrder(due expiry) {
+	if bookOrder := in.orderBook.GetOrder(due.orderID); bookOrder != nil {
+		if bookOrder.expireAt != due.expireAt {
+			return
+		}
+		in.orderBook.RemoveOrder(bookOrder.ID)
+		in.publish(OrderExpired{
+			OrderID:   bookOrder.ID,
+			OrdererID: bookOrder.OrdererID,
+			Side:      bookOrder.Side,
+			Price:     bookOrder.Price,
+			Quantity:  bookOrder.openQuantity(),
+			ExpireAt:  due.expireAt,
+		})
+		return
+	}
+
+	if item, ok := in.stopOrders[due.orderID]; ok && expiresAt(item.value) == due.expireAt {
+		stopOrder := item.value
+		in.removeStopOrder(item)
+		in.publish(OrderExpired{
+			OrderID:   stopOrder.ID,
+			OrdererID: stopOrder.OrdererID,
+			Side:      stopOrder.Side,
+			Price:     stopOrder.Price,
+			Quantity:  stopOrder.Quantity,
+			ExpireAt:  due.expireAt,
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package matching
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchingEngine_OrderExpiry(t *testing.T) {
+	start := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC).UnixNano()
+
+	t.Run("should expire a GTD order when the clock reaches it", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.AdvanceClock(&ClockTick{Time: start})
+		expireAt := start + int64(time.Minute)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10, TimeInForce: "gtd", ExpireAt: expireAt})
+
+		me.AdvanceClock(&ClockTick{Time: expireAt - 1})
+		if me.GetOrderBook().BestBid() == nil {
+			t.Fatal("Expected the order to still be on the book")
+		}
+
+		me.AdvanceClock(&ClockTick{Time: expireAt})
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected an event, but got none")
+		}
+		if expired, ok := event.Report.(OrderExpired); !ok || expired.OrderID != 1 || expired.Quantity != 10 {
+			t.Errorf("Expected order 1 to expire, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestBid() != nil {
+			t.Errorf("Expected the order to be removed, got %v", me.GetOrderBook().BestBid())
+		}
+	})
+
+	t.Run("should expire DAY orders at the end of the day", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.AdvanceClock(&ClockTick{Time: start})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10, TimeInForce: "day"})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 10})
+
+		me.AdvanceClock(&ClockTick{Time: start + int64(14*time.Hour)})
+		if me.GetOrderBook().BestAsk().ID != 1 {
+			t.Fatal("Expected the DAY order to survive until midnight")
+		}
+
+		me.AdvanceClock(&ClockTick{Time: start + int64(15*time.Hour)})
+		event, _ := outputBuffer.Pop()
+		if _, ok := event.Report.(OrderExpired); !ok {
+			t.Errorf("Expected an expired event, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestAsk().ID != 2 {
+			t.Errorf("Expected only the GTC order to be left, got %v", me.GetOrderBook().BestAsk())
+		}
+	})
+
+	t.Run("should not report orders that already left the book", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.AdvanceClock(&ClockTick{Time: start})
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10, TimeInForce: "gtd", ExpireAt: start + 10})
+		me.CancelOrder(&CancelRequest{OrderID: 1, OrdererID: 7})
+		outputBuffer.Pop()
+
+		me.AdvanceClock(&ClockTick{Time: start + 10})
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no events, got %d", outputBuffer.Size())
+		}
+	})
+
+	t.Run("should not expire a later order that reuses the ID", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.AdvanceClock(&ClockTick{Time: start})
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10, TimeInForce: "gtd", ExpireAt: start + 10})
+		me.CancelOrder(&CancelRequest{OrderID: 1, OrdererID: 7})
+		outputBuffer.Pop()
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		me.AdvanceClock(&ClockTick{Time: start + 10})
+		if outputBuffer.Size() != 0 {
+			event, _ := outputBuffer.Pop()
+			t.Errorf("Expected no events, but got %v", event.Report)
+		}
+		if best := me.GetOrderBook().BestBid(); best == nil || best.ID != 1 {
+			t.Errorf("Expected the GTC order to stay on the book, got %+v", best)
+		}
+	})
+
+	t.Run("should not schedule the expiry of a rejected order", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.AdvanceClock(&ClockTick{Time: start})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "post-only", Side: "buy", Price: 100 * PricePrecision, Quantity: 10, TimeInForce: "gtd", ExpireAt: start + 10})
+		outputBuffer.Pop()
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 10})
+
+		me.AdvanceClock(&ClockTick{Time: start + 10})
+		if outputBuffer.Size() != 0 {
+			event, _ := outputBuffer.Pop()
+			t.Errorf("Expected no events, but got %v", event.Report)
+		}
+		if me.instruments[DefaultSymbol].expiries.Len() != 0 {
+			t.Errorf("Expected no expiries, got %d", me.instruments[DefaultSymbol].expiries.Len())
+		}
+	})
+
+	t.Run("should keep the expiry of an amended order", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.AdvanceClock(&ClockTick{Time: start})
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10, TimeInForce: "gtd", ExpireAt: start + 10})
+		me.AmendOrder(&AmendRequest{OrderID: 1, OrdererID: 7, Price: 99 * PricePrecision})
+		outputBuffer.Pop()
+
+		me.AdvanceClock(&ClockTick{Time: start + 10})
+		event, _ := outputBuffer.Pop()
+		if expired, ok := event.Report.(OrderExpired); !ok || expired.OrderID != 1 || expired.Price != 99*PricePrecision {
+			t.Errorf("Expected the amended order to expire, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should keep the expiry of a triggered stop-limit", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.AdvanceClock(&ClockTick{Time: start})
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-limit", Side: "buy", TriggerPrice: 100 * PricePrecision, Price: 99 * PricePrecision, Quantity: 5, TimeInForce: "gtd", ExpireAt: start + 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 1})
+		outputBuffer.Pop() // trade between 2 and 3
+		if best := me.GetOrderBook().BestBid(); best == nil || best.ID != 1 {
+			t.Fatalf("Expected the triggered stop-limit to rest, got %+v", best)
+		}
+
+		me.AdvanceClock(&ClockTick{Time: start + 10})
+		event, _ := outputBuffer.Pop()
+		if expired, ok := event.Report.(OrderExpired); !ok || expired.OrderID != 1 {
+			t.Errorf("Expected the resting stop-limit to expire, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should expire pending stop orders", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-loss", Side: "sell", Price: 98 * PricePrecision, Quantity: 5, TimeInForce: "gtd", ExpireAt: start})
+
+		me.AdvanceClock(&ClockTick{Time: start})
+		event, _ := outputBuffer.Pop()
+		if _, ok := event.Report.(OrderExpired); !ok {
+			t.Errorf("Expected an expired event, but got %v", event.Report)
+		}
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 0 {
+			t.Errorf("Expected 0 stop orders, got %d", me.instruments[DefaultSymbol].sellStopOrders.Len())
+		}
+	})
+
+	t.Run("should reject a GTD order that has already expired", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.AdvanceClock(&ClockTick{Time: start})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10, TimeInForce: "gtd", ExpireAt: start})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectInvalidExpiry {
+			t.Errorf("Expected an invalid expiry rejection, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should process clock ticks from the input buffer", func(t *testing.T) {
+		me := NewMatchingEngine(NewRingBuffer(1024))
+		me.SubmitClockTick(&ClockTick{Time: start})
+
+		if me.inputBuffer.Size() != 1 {
+			t.Errorf("Expected 1 command in the input buffer, got %d", me.inputBuffer.Size())
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package matching
+
+// A FeeSchedule sets what one tier of orderers pays on its trades, in basis
+// points of the trade's notional (Price × Quantity, in the fixed-point units
+// of PricePrecision). A negative MakerBasisPoints pays the maker a rebate.
+// MinimumFee is the least a side that pays a fee is charged; rebates have no
+// minimum.
+type FeeSchedule struct {
+	MakerBasisPoints int64
+	TakerBasisPoints int64
+	MinimumFee       int64
+}
+
+// SetFeeTier puts an orderer in a fee tier. Orderers without a tier, or
+// whose tier an instrument has no schedule for, pay the instrument's default
+// ("") schedule. It must not be called while Run is processing commands.
+func (me *MatchingEngine) SetFeeTier(ordererID int, tier string) {
+	if tier == "" {
+		delete(me.feeTiers, ordererID)
+		return
+	}
+	me.feeTiers[ordererID] = tier
+}
+
+func (in *instrument) feeSchedule(ordererID int) FeeSchedule {
+	schedules := in.orderBook.config.FeeSchedules
+	if schedule, ok := schedules[in.engine.feeTiers[ordererID]]; ok {
+		return schedule
+	}
+	return schedules[""]
+}
+
+// chargeFees fills in the fees for both sides of a trade. Auction trades have
+// no liquidity provider, so both sides pay their taker rate.
+func (in *instrument) chargeFees(trade *Trade, takerOrdererID, makerOrdererID int) {
+	if len(in.orderBook.config.FeeSchedules) == 0 {
+		return
+	}
+	notional := trade.Price * int64(trade.Quantity)
+	taker := in.feeSchedule(takerOrdererID)
+	maker := in.feeSchedule(makerOrdererID)
+	trade.TakerFee = fee(notional, taker.TakerBasisPoints, taker.MinimumFee)
+	if trade.Auction {
+		trade.MakerFee = fee(notional, maker.TakerBasisPoints, maker.MinimumFee)
+	} else {
+		trade.MakerFee = fee(notional, maker.MakerBasisPoints, maker.MinimumFee)
+	}
+}
+
+// fee returns basisPoints of notional, rounded up: fees round up and rebates
+// round towards zero, both in the venue's favour. The notional is split
+// before multiplying so large trades cannot overflow.
+func fee(notional, basisPoints, minimum int64) int64 {
+	if basisPoints == 0 {
+		return 0
+	}
+	amount := notional/10000*basisPoints + ceilDiv(notional%10000*basisPoints, 10000)
+	if basisPoints > 0 && amount < minimum {
+		return minimum
+	}
+	return amount
+}
+
+// ceilDiv divides rounding towards positive infinity.
+func ceilDiv(a, b int64) int64 {
+	q := a / b
+	if a%b > 0 {
+		q++
+	}
+	return q
+}
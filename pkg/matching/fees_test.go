@@ -0,0 +1,128 @@
+package matching
+
+import (
+	"testing"
+)
+
+func TestFee(t *testing.T) {
+	tests := []struct {
+		name        string
+		notional    int64
+		basisPoints int64
+		minimum     int64
+		expected    int64
+	}{
+		{"whole fee", 1_000_000, 5, 0, 500},
+		{"rounds fees up", 1_000_001, 5, 0, 501},
+		{"rounds rebates towards zero", 1_000_001, -2, 0, -200},
+		{"applies the minimum", 1_000, 5, 10, 10},
+		{"no minimum on rebates", 1_000, -2, 10, 0},
+		{"no minimum at a zero rate", 1_000, 0, 10, 0},
+		{"large notional", 9_000_000_000_000_000_000, 10, 0, 9_000_000_000_000_000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fee(tt.notional, tt.basisPoints, tt.minimum); got != tt.expected {
+				t.Errorf("Expected fee %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestMatchingEngine_Fees(t *testing.T) {
+	newEngine := func() (*MatchingEngine, *testOutput) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{
+			MinTickSize: 1,
+			FeeSchedules: map[string]FeeSchedule{
+				"":    {MakerBasisPoints: 2, TakerBasisPoints: 5, MinimumFee: 100},
+				"vip": {MakerBasisPoints: -1, TakerBasisPoints: 3},
+			},
+		})
+		me.SetFeeTier(9, "vip")
+		return me, outputBuffer
+	}
+
+	popTrade := func(t *testing.T, outputBuffer *testOutput) Trade {
+		t.Helper()
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected a trade, but got none")
+		}
+		trade, ok := event.Report.(Trade)
+		if !ok {
+			t.Fatalf("Expected a trade, but got %v", event.Report)
+		}
+		return trade
+	}
+
+	t.Run("should charge the default tier's maker and taker rates", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		// Notional 10 × 100.0000 = 10,000,000; 5bp = 5,000 and 2bp = 2,000.
+		trade := popTrade(t, outputBuffer)
+		if trade.TakerFee != 5_000 || trade.MakerFee != 2_000 {
+			t.Errorf("Expected fees 5000 and 2000, got %d and %d", trade.TakerFee, trade.MakerFee)
+		}
+	})
+
+	t.Run("should pay a rebate to a maker in a rebate tier", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 9, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		trade := popTrade(t, outputBuffer)
+		if trade.TakerFee != 5_000 || trade.MakerFee != -1_000 {
+			t.Errorf("Expected fees 5000 and -1000, got %d and %d", trade.TakerFee, trade.MakerFee)
+		}
+	})
+
+	t.Run("should apply the minimum fee to small trades", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 1, Type: "limit", Side: "sell", Price: 1, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 2, Type: "limit", Side: "buy", Price: 1, Quantity: 1})
+
+		trade := popTrade(t, outputBuffer)
+		if trade.TakerFee != 100 || trade.MakerFee != 100 {
+			t.Errorf("Expected both sides to pay the minimum of 100, got %d and %d", trade.TakerFee, trade.MakerFee)
+		}
+	})
+
+	t.Run("should charge both sides the taker rate in an auction", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.ChangePhase(&PhaseChange{Phase: PhaseAuction})
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 9, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+		me.ChangePhase(&PhaseChange{Phase: PhaseContinuous})
+
+		var trade Trade
+		for {
+			event, ok := outputBuffer.Pop()
+			if !ok {
+				t.Fatal("Expected an auction trade, but got none")
+			}
+			if report, ok := event.Report.(Trade); ok {
+				trade = report
+				break
+			}
+		}
+		if !trade.Auction || trade.TakerFee != 5_000 || trade.MakerFee != 3_000 {
+			t.Errorf("Expected taker-rate fees 5000 and 3000, got %+v", trade)
+		}
+	})
+
+	t.Run("should leave trades free without schedules", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: 1})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		trade := popTrade(t, outputBuffer)
+		if trade.TakerFee != 0 || trade.MakerFee != 0 {
+			t.Errorf("Expected no fees, got %+v", trade)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package matching
+
+import (
+	"container/heap"
+)
+
+// An instrument holds everything the engine keeps for one traded symbol: its
+// order book, pending stop orders, expiries and last trade price.
+type instrument struct {
+	engine         *MatchingEngine
+	symbol         string
+	orderBook      *OrderBook
+	buyStopOrders  *StopLossQueue
+	sellStopOrders *StopLossQueue
+	stopOrders     map[int]*StopLossOrder
+	stopSequence   uint64
+	// Trailing stops are also kept by their high (sell) or low (buy) water
+	// mark, so a trade only touches the stops whose trigger actually moves.
+	buyTrailingStops  *StopLossQueue
+	sellTrailingStops *StopLossQueue
+	trailingStops     map[int]*StopLossOrder
+	expiries          *expiryQueue
+	expirySequence    uint64
+	lastTradePrice    int64
+	// Rolling trade windows for the VWAP band reference and the volatility
+	// halt; nil when the instrument does not use them.
+	vwapWindow       *tradeWindow
+	volatilityWindow *tradeWindow
+	phase            string
+	phaseEndsAt      int64 // Engine time a timed halt or auction ends; 0 if it has no end.
+	indicative       IndicativeAuctionPrice
+	policy           MatchingPolicy
+	// Scratch space planFills reuses for the orders at one level and their
+	// allocations.
+	levelMakers []*BookOrder
+	allocations []int
+}
+
+func newInstrument(engine *MatchingEngine, config *OrderBookConfig) *instrument {
+	buyStopOrders := &StopLossQueue{}
+	sellStopOrders := &StopLossQueue{}
+	heap.Init(buyStopOrders)
+	heap.Init(sellStopOrders)
+	in := &instrument{
+		engine:            engine,
+		symbol:            config.Symbol,
+		orderBook:         NewOrderBook(config),
+		buyStopOrders:     buyStopOrders,
+		sellStopOrders:    sellStopOrders,
+		stopOrders:        make(map[int]*StopLossOrder),
+		buyTrailingStops:  &StopLossQueue{},
+		sellTrailingStops: &StopLossQueue{},
+		trailingStops:     make(map[int]*StopLossOrder),
+		expiries:          &expiryQueue{},
+		phase:             PhaseContinuous,
+	}
+	// AddInstrument has validated the config, so the algorithm is known.
+	in.policy, _ = newMatchingPolicy(config)
+	if config.OpeningAuction {
+		in.phase = PhaseAuction
+	}
+	if config.PriceBandReference == PriceReferenceVWAP {
+		in.vwapWindow = &tradeWindow{length: config.VWAPWindow}
+	}
+	if config.VolatilityBasisPoints > 0 {
+		in.volatilityWindow = &tradeWindow{length: config.VolatilityWindow}
+	}
+	return in
+}
+
+func (in *instrument) publish(report ExecutionReport) {
+	in.engine.publish(in.symbol, report)
+}
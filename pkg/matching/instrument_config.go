@@ -0,0 +1,158 @@
+package matching
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// A TickBand sets the tick size for prices from MinPrice up to the MinPrice of
+// the next band. Bands are listed in ascending order of MinPrice, and each
+// band's MinPrice should lie on the tick grid of the band below it.
+type TickBand struct {
+	MinPrice int64
+	TickSize int64
+}
+
+// tickBand returns where the band that price falls in starts and its tick
+// size.
+func (c *OrderBookConfig) tickBand(price int64) (start, tick int64) {
+	tick = c.MinTickSize
+	for _, band := range c.TickLadder {
+		if price < band.MinPrice {
+			break
+		}
+		start, tick = band.MinPrice, band.TickSize
+	}
+	return start, tick
+}
+
+// TickSize returns the tick size that applies at price.
+func (c *OrderBookConfig) TickSize(price int64) int64 {
+	_, tick := c.tickBand(price)
+	return tick
+}
+
+// ticksAbove returns the price n ticks above price, stepping a whole band of
+// the ladder at a time. It saturates at the largest int64 instead of
+// overflowing.
+func (c *OrderBookConfig) ticksAbove(price, n int64) int64 {
+	for i := 0; n > 0; {
+		_, tick := c.tickBand(price)
+		for i < len(c.TickLadder) && c.TickLadder[i].MinPrice <= price {
+			i++
+		}
+		if i < len(c.TickLadder) {
+			// Ticks needed to reach the next band, which may use another size.
+			if toNext := (c.TickLadder[i].MinPrice - price + tick - 1) / tick; toNext <= n {
+				price += toNext * tick
+				n -= toNext
+				continue
+			}
+		}
+		if n > (math.MaxInt64-price)/tick {
+			return math.MaxInt64
+		}
+		return price + n*tick
+	}
+	return price
+}
+
+// ticksBelow returns the price n ticks below price, stepping a whole band of
+// the ladder at a time. It stops at the lowest positive price on the grid.
+func (c *OrderBookConfig) ticksBelow(price, n int64) int64 {
+	for n > 0 {
+		// The ticks below price run down from the grid point under it to
+		// the start of its band; the band below takes over from there.
+		start, tick := c.tickBand(price - 1)
+		first := start + (price-1-start)/tick*tick
+		below := (first-start)/tick + 1
+		if start == 0 {
+			// The lowest band, where zero is not a price.
+			if below--; below == 0 {
+				return price
+			}
+			return first - (min(n, below)-1)*tick
+		}
+		if n <= below {
+			return first - (n-1)*tick
+		}
+		price = start
+		n -= below
+	}
+	return price
+}
+
+// Validate reports the first inconsistency in the config, if any.
+func (c *OrderBookConfig) Validate() error {
+	if c.MinTickSize <= 0 {
+		return fmt.Errorf("instrument %q: MinTickSize must be positive", c.Symbol)
+	}
+	for i, band := range c.TickLadder {
+		if band.TickSize <= 0 {
+			return fmt.Errorf("instrument %q: tick band %d must have a positive TickSize", c.Symbol, i)
+		}
+		if band.MinPrice <= 0 || (i > 0 && band.MinPrice <= c.TickLadder[i-1].MinPrice) {
+			return fmt.Errorf("instrument %q: tick band %d must start above the band before it", c.Symbol, i)
+		}
+	}
+	if !validSelfTradePrevention(c.SelfTradePrevention) {
+		return fmt.Errorf("instrument %q: unknown self-trade prevention mode %q", c.Symbol, c.SelfTradePrevention)
+	}
+	if c.LotSize < 0 || c.MinQuantity < 0 || c.MaxQuantity < 0 || c.MinPrice < 0 || c.MaxPrice < 0 || c.MinNotional < 0 {
+		return fmt.Errorf("instrument %q: order limits must not be negative", c.Symbol)
+	}
+	if c.PriceBandBasisPoints < 0 || c.VolatilityBasisPoints < 0 || c.VolatilityWindow < 0 || c.HaltDuration < 0 || c.AuctionDuration < 0 {
+		return fmt.Errorf("instrument %q: price band, volatility and auction settings must not be negative", c.Symbol)
+	}
+	switch c.PriceBandReference {
+	case "", PriceReferenceLastTrade:
+	case PriceReferenceVWAP:
+		if c.VWAPWindow <= 0 {
+			return fmt.Errorf("instrument %q: a VWAP reference needs a positive VWAPWindow", c.Symbol)
+		}
+	default:
+		return fmt.Errorf("instrument %q: unknown price band reference %q", c.Symbol, c.PriceBandReference)
+	}
+	if c.VolatilityBasisPoints > 0 && c.HaltDuration == 0 {
+		return fmt.Errorf("instrument %q: a volatility threshold needs a HaltDuration", c.Symbol)
+	}
+	if _, err := newMatchingPolicy(c); err != nil {
+		return err
+	}
+	if c.ProRataMinimumAllocation < 0 {
+		return fmt.Errorf("instrument %q: ProRataMinimumAllocation must not be negative", c.Symbol)
+	}
+	for tier, schedule := range c.FeeSchedules {
+		if schedule.TakerBasisPoints < 0 || schedule.MinimumFee < 0 {
+			return fmt.Errorf("instrument %q: fee tier %q must not have a negative taker fee or minimum", c.Symbol, tier)
+		}
+	}
+	if c.MaxQuantity > 0 && c.MinQuantity > c.MaxQuantity {
+		return fmt.Errorf("instrument %q: MinQuantity is above MaxQuantity", c.Symbol)
+	}
+	if c.MaxPrice > 0 && c.MinPrice > c.MaxPrice {
+		return fmt.Errorf("instrument %q: MinPrice is above MaxPrice", c.Symbol)
+	}
+	return nil
+}
+
+// LoadInstrumentConfigs reads a JSON array of instrument configs from path,
+// one OrderBookConfig per instrument, and validates each of them.
+func LoadInstrumentConfigs(path string) ([]*OrderBookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []*OrderBookConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, config := range configs {
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
@@ -0,0 +1,142 @@
+package matching
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOrderBookConfig_TickLadder(t *testing.T) {
+	config := &OrderBookConfig{
+		MinTickSize: 1,
+		TickLadder: []TickBand{
+			{MinPrice: 1000, TickSize: 5},
+			{MinPrice: 10000, TickSize: 50},
+		},
+	}
+	ob := NewOrderBook(config)
+
+	t.Run("should pick the tick size of the band a price falls in", func(t *testing.T) {
+		cases := map[int64]int64{999: 1, 1000: 5, 9999: 5, 10000: 50, 123456: 50}
+		for price, expected := range cases {
+			if tick := config.TickSize(price); tick != expected {
+				t.Errorf("Expected tick size %d at %d, got %d", expected, price, tick)
+			}
+		}
+	})
+
+	t.Run("should round down to the grid of the band", func(t *testing.T) {
+		cases := map[int64]int64{999: 999, 1003: 1000, 1007: 1005, 10049: 10000, 10075: 10050}
+		for price, expected := range cases {
+			if rounded := ob.roundPrice(price); rounded != expected {
+				t.Errorf("Expected %d to round to %d, got %d", price, expected, rounded)
+			}
+		}
+	})
+
+	t.Run("should step ticks across bands like one tick at a time", func(t *testing.T) {
+		for _, price := range []int64{1, 2, 995, 999, 1000, 1005, 9995, 10000, 10050} {
+			for _, n := range []int64{1, 2, 5, 200, 1000, 3000} {
+				up, down := price, price
+				for i := int64(0); i < n; i++ {
+					up += config.TickSize(up)
+					if next := ob.roundPrice(down - 1); next > 0 {
+						down = next
+					}
+				}
+				if above := config.ticksAbove(price, n); above != up {
+					t.Errorf("Expected %d ticks above %d to be %d, got %d", n, price, up, above)
+				}
+				if below := config.ticksBelow(price, n); below != down {
+					t.Errorf("Expected %d ticks below %d to be %d, got %d", n, price, down, below)
+				}
+			}
+		}
+	})
+
+	t.Run("should not overflow far above the ladder", func(t *testing.T) {
+		if above := config.ticksAbove(1000, 1<<62); above != math.MaxInt64 {
+			t.Errorf("Expected ticks above to stop at %d, got %d", int64(math.MaxInt64), above)
+		}
+		if below := config.ticksBelow(123450, 1<<62); below != 1 {
+			t.Errorf("Expected ticks below to stop at 1, got %d", below)
+		}
+	})
+}
+
+func TestOrderBookConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *OrderBookConfig
+		valid  bool
+	}{
+		{"plain tick size", &OrderBookConfig{MinTickSize: 1}, true},
+		{"missing tick size", &OrderBookConfig{}, false},
+		{"ascending ladder", &OrderBookConfig{MinTickSize: 1, TickLadder: []TickBand{{MinPrice: 100, TickSize: 5}, {MinPrice: 1000, TickSize: 10}}}, true},
+		{"unsorted ladder", &OrderBookConfig{MinTickSize: 1, TickLadder: []TickBand{{MinPrice: 1000, TickSize: 10}, {MinPrice: 100, TickSize: 5}}}, false},
+		{"zero band tick size", &OrderBookConfig{MinTickSize: 1, TickLadder: []TickBand{{MinPrice: 100}}}, false},
+		{"unknown STP mode", &OrderBookConfig{MinTickSize: 1, SelfTradePrevention: "bogus"}, false},
+		{"negative lot size", &OrderBookConfig{MinTickSize: 1, LotSize: -1}, false},
+		{"inverted quantity limits", &OrderBookConfig{MinTickSize: 1, MinQuantity: 10, MaxQuantity: 5}, false},
+		{"inverted price range", &OrderBookConfig{MinTickSize: 1, MinPrice: 10, MaxPrice: 5}, false},
+		{"VWAP band without a window", &OrderBookConfig{MinTickSize: 1, PriceBandBasisPoints: 500, PriceBandReference: PriceReferenceVWAP}, false},
+		{"unknown band reference", &OrderBookConfig{MinTickSize: 1, PriceBandBasisPoints: 500, PriceBandReference: "mid"}, false},
+		{"volatility threshold without a halt duration", &OrderBookConfig{MinTickSize: 1, VolatilityBasisPoints: 500, VolatilityWindow: 1000}, false},
+		{"maker rebate", &OrderBookConfig{MinTickSize: 1, FeeSchedules: map[string]FeeSchedule{"": {MakerBasisPoints: -2, TakerBasisPoints: 5}}}, true},
+		{"negative taker fee", &OrderBookConfig{MinTickSize: 1, FeeSchedules: map[string]FeeSchedule{"": {TakerBasisPoints: -1}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Expected the config to be valid, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected the config to be rejected")
+			}
+		})
+	}
+}
+
+func TestLoadInstrumentConfigs(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("should load one config per instrument", func(t *testing.T) {
+		path := filepath.Join(dir, "instruments.json")
+		data := `[
+			{"Symbol": "BTC-USD", "MinTickSize": 1, "LotSize": 10, "MinNotional": 100000,
+			 "TickLadder": [{"MinPrice": 1000, "TickSize": 5}]},
+			{"Symbol": "ETH-USD", "MinTickSize": 100}
+		]`
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		configs, err := LoadInstrumentConfigs(path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(configs) != 2 {
+			t.Fatalf("Expected 2 configs, got %d", len(configs))
+		}
+		btc := configs[0]
+		if btc.Symbol != "BTC-USD" || btc.LotSize != 10 || btc.MinNotional != 100000 || len(btc.TickLadder) != 1 || btc.TickLadder[0].TickSize != 5 {
+			t.Errorf("Unexpected BTC-USD config: %+v", btc)
+		}
+		if configs[1].Symbol != "ETH-USD" || configs[1].MinTickSize != 100 {
+			t.Errorf("Unexpected ETH-USD config: %+v", configs[1])
+		}
+	})
+
+	t.Run("should reject an invalid config", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.json")
+		if err := os.WriteFile(path, []byte(`[{"Symbol": "BTC-USD"}]`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadInstrumentConfigs(path); err == nil {
+			t.Error("Expected an error for a config without a tick size")
+		}
+	})
+}
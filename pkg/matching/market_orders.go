@@ -0,0 +1,129 @@
+package matching
+
+const (
+	CancelReasonMarketUnfilled  = "market-unfilled"
+	CancelReasonProtectionLimit = "protection-limit"
+	CancelReasonPriceBand       = "price-band"
+
+	RejectInvalidProtection = "invalid-protection"
+)
+
+// matchMarketOrder takes liquidity until the order is filled, the book runs
+// out, or the order's protection limit or the price band is reached. A
+// market-to-limit order that traded rests its remainder at its last execution
+// price; any other remainder is cancelled and reported with the reason it
+// stopped.
+func (in *instrument) matchMarketOrder(order *Order) {
+	protected := in.setProtectionLimit(order)
+	lastPrice := in.fillOrder(order, protected)
+	if order.Quantity == 0 {
+		return
+	}
+
+	if order.Type == "market-to-limit" && lastPrice > 0 {
+		in.publish(OrderPriceAdjusted{
+			OrderID:   order.ID,
+			OrdererID: order.OrdererID,
+			OldPrice:  order.Price,
+			Price:     lastPrice,
+		})
+		order.Price = lastPrice
+		in.restOrder(order)
+		return
+	}
+
+	in.cancelRemainder(order, in.unfilledReason(order, protected))
+}
+
+// setProtectionLimit turns an order's protection, given in ticks or basis
+// points away from the best opposite price on arrival, into a limit price in
+// order.Price. It reports whether the order is limited.
+func (in *instrument) setProtectionLimit(order *Order) bool {
+	if order.ProtectionTicks == 0 && order.ProtectionBasisPoints == 0 {
+		return false
+	}
+	best := in.orderBook.BestAsk()
+	if order.Side == "sell" {
+		best = in.orderBook.BestBid()
+	}
+	if best == nil {
+		return false
+	}
+
+	config := in.orderBook.config
+	limit := best.Price
+	if order.ProtectionTicks > 0 && order.Side == "buy" {
+		limit = config.ticksAbove(limit, int64(order.ProtectionTicks))
+		if config.MaxPrice >= best.Price && limit > config.MaxPrice {
+			limit = config.MaxPrice
+		}
+	} else if order.ProtectionTicks > 0 {
+		limit = config.ticksBelow(limit, int64(order.ProtectionTicks))
+	} else if order.Side == "buy" {
+		limit = in.orderBook.roundPrice(limit + limit*order.ProtectionBasisPoints/10000)
+	} else {
+		floor := limit - limit*order.ProtectionBasisPoints/10000
+		if limit = in.orderBook.roundPrice(floor); limit < floor {
+			limit += config.TickSize(limit)
+		}
+		if limit <= 0 {
+			return false
+		}
+	}
+	order.Price = limit
+	return true
+}
+
+// unfilledReason tells why a market order stopped with quantity left: the
+// book ran out, or the liquidity left lies beyond the order's protection limit
+// or the price band. If it lies beyond both, the nearer one stopped the order.
+func (in *instrument) unfilledReason(order *Order, protected bool) string {
+	best := in.orderBook.BestAsk()
+	if order.Side == "sell" {
+		best = in.orderBook.BestBid()
+	}
+	if best == nil {
+		return CancelReasonMarketUnfilled
+	}
+	low, high, banded := in.priceBand()
+	beyondBand := banded && (best.Price < low || best.Price > high)
+	beyondLimit := protected && !crosses(order, best.Price)
+	switch {
+	case beyondBand && beyondLimit:
+		if (order.Side == "buy" && high < order.Price) || (order.Side == "sell" && low > order.Price) {
+			return CancelReasonPriceBand
+		}
+		return CancelReasonProtectionLimit
+	case beyondBand:
+		return CancelReasonPriceBand
+	case beyondLimit:
+		return CancelReasonProtectionLimit
+	}
+	return CancelReasonMarketUnfilled
+}
+
+// maxProtectionTicks bounds ProtectionTicks. A protection further away than
+// this is no protection at all.
+const maxProtectionTicks = 1_000_000
+
+// validateProtection checks a market order's protection: at most one of
+// ticks and basis points, and only on orders that execute at market.
+func validateProtection(order *Order) string {
+	if order.ProtectionTicks == 0 && order.ProtectionBasisPoints == 0 {
+		return ""
+	}
+	if order.ProtectionTicks < 0 || order.ProtectionTicks > maxProtectionTicks || order.ProtectionBasisPoints < 0 ||
+		(order.ProtectionTicks > 0 && order.ProtectionBasisPoints > 0) ||
+		order.ProtectionBasisPoints >= 10000 {
+		return RejectInvalidProtection
+	}
+	switch order.Type {
+	case "market", "market-to-limit", "stop-loss", "trailing-stop":
+		return ""
+	}
+	return RejectInvalidProtection
+}
+
+func isMarketType(orderType string) bool {
+	return orderType == "market" || orderType == "market-to-limit"
+}
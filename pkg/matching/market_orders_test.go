@@ -0,0 +1,199 @@
+package matching
+
+import (
+	"testing"
+)
+
+func TestMatchingEngine_MarketOrders(t *testing.T) {
+	// A ladder of asks at 100, 101, 102 and 105, five lots each.
+	setup := func() (*MatchingEngine, *testOutput) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: PricePrecision})
+		for i, price := range []int64{100, 101, 102, 105} {
+			me.PlaceOrder(&Order{ID: i + 1, Type: "limit", Side: "sell", Price: price * PricePrecision, Quantity: 5})
+		}
+		return me, outputBuffer
+	}
+
+	popReports := func(outputBuffer *testOutput) (trades []Trade, others []ExecutionReport) {
+		for {
+			event, ok := outputBuffer.Pop()
+			if !ok {
+				return trades, others
+			}
+			if trade, ok := event.Report.(Trade); ok {
+				trades = append(trades, trade)
+			} else {
+				others = append(others, event.Report)
+			}
+		}
+	}
+
+	t.Run("should report the remainder when the book runs out", func(t *testing.T) {
+		me, outputBuffer := setup()
+		me.PlaceOrder(&Order{ID: 10, Type: "market", Side: "buy", Quantity: 25})
+
+		trades, others := popReports(outputBuffer)
+		if len(trades) != 4 {
+			t.Errorf("Expected 4 trades, got %d", len(trades))
+		}
+		if len(others) != 1 {
+			t.Fatalf("Expected one cancellation, got %v", others)
+		}
+		if cancelled, ok := others[0].(OrderCancelled); !ok || cancelled.Quantity != 5 || cancelled.Reason != CancelReasonMarketUnfilled {
+			t.Errorf("Expected 5 to be cancelled as market-unfilled, got %v", others[0])
+		}
+	})
+
+	t.Run("should stop at a protection limit in ticks", func(t *testing.T) {
+		me, outputBuffer := setup()
+		me.PlaceOrder(&Order{ID: 10, Type: "market", Side: "buy", Quantity: 20, ProtectionTicks: 1})
+
+		trades, others := popReports(outputBuffer)
+		if len(trades) != 2 || trades[1].Price != 101*PricePrecision {
+			t.Errorf("Expected trades at 100 and 101 only, got %v", trades)
+		}
+		if len(others) != 1 {
+			t.Fatalf("Expected one cancellation, got %v", others)
+		}
+		cancelled, ok := others[0].(OrderCancelled)
+		if !ok || cancelled.Quantity != 10 || cancelled.Reason != CancelReasonProtectionLimit || cancelled.Price != 101*PricePrecision {
+			t.Errorf("Expected 10 to be cancelled at the 101 protection limit, got %v", others[0])
+		}
+		if best := me.GetOrderBook().BestAsk(); best == nil || best.ID != 3 {
+			t.Errorf("Expected order 3 to be the best ask, got %+v", best)
+		}
+	})
+
+	t.Run("should stop at a protection limit in basis points", func(t *testing.T) {
+		// 2.5% above 100 is 102.5, which rounds down to 102.
+		me, outputBuffer := setup()
+		me.PlaceOrder(&Order{ID: 10, Type: "market", Side: "buy", Quantity: 20, ProtectionBasisPoints: 250})
+
+		trades, _ := popReports(outputBuffer)
+		if len(trades) != 3 || trades[2].Price != 102*PricePrecision {
+			t.Errorf("Expected trades up to 102, got %v", trades)
+		}
+		if best := me.GetOrderBook().BestAsk(); best == nil || best.ID != 4 {
+			t.Errorf("Expected order 4 to be the best ask, got %+v", best)
+		}
+	})
+
+	t.Run("should protect a sell from the best bid", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: PricePrecision})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 90 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 10, Type: "market", Side: "sell", Quantity: 10, ProtectionBasisPoints: 500})
+
+		trades, _ := popReports(outputBuffer)
+		if len(trades) != 1 || trades[0].MakerOrderID != 1 {
+			t.Errorf("Expected a single trade against order 1, got %v", trades)
+		}
+	})
+
+	t.Run("should rest a market-to-limit remainder at its last execution price", func(t *testing.T) {
+		me, outputBuffer := setup()
+		me.PlaceOrder(&Order{ID: 10, Type: "market-to-limit", Side: "buy", Quantity: 15, ProtectionTicks: 1})
+
+		trades, others := popReports(outputBuffer)
+		if len(trades) != 2 {
+			t.Errorf("Expected 2 trades, got %v", trades)
+		}
+		if len(others) != 1 {
+			t.Fatalf("Expected one price adjustment, got %v", others)
+		}
+		if adjusted, ok := others[0].(OrderPriceAdjusted); !ok || adjusted.Price != 101*PricePrecision {
+			t.Errorf("Expected the order to be repriced to 101, got %v", others[0])
+		}
+		best := me.GetOrderBook().BestBid()
+		if best == nil || best.ID != 10 || best.Price != 101*PricePrecision || best.Quantity != 5 {
+			t.Errorf("Expected 5 to rest at 101, got %+v", best)
+		}
+	})
+
+	t.Run("should cancel a market-to-limit order that cannot trade", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: PricePrecision})
+		me.PlaceOrder(&Order{ID: 10, Type: "market-to-limit", Side: "buy", Quantity: 5})
+
+		_, others := popReports(outputBuffer)
+		if len(others) != 1 {
+			t.Fatalf("Expected one cancellation, got %v", others)
+		}
+		if cancelled, ok := others[0].(OrderCancelled); !ok || cancelled.Reason != CancelReasonMarketUnfilled {
+			t.Errorf("Expected a market-unfilled cancellation, got %v", others[0])
+		}
+		if me.GetOrderBook().BestBid() != nil {
+			t.Error("Expected nothing to rest on the book")
+		}
+	})
+
+	t.Run("should pass protection on to a triggered stop", func(t *testing.T) {
+		me, outputBuffer := setup()
+		me.PlaceOrder(&Order{ID: 10, Type: "stop-loss", Side: "buy", TriggerPrice: 100 * PricePrecision, Quantity: 15, ProtectionTicks: 1})
+		me.PlaceOrder(&Order{ID: 11, Type: "market", Side: "buy", Quantity: 1})
+
+		trades, others := popReports(outputBuffer)
+		if len(trades) != 3 || trades[2].Price != 101*PricePrecision {
+			t.Errorf("Expected the stop to trade up to 101 only, got %v", trades)
+		}
+		if len(others) != 1 {
+			t.Fatalf("Expected one cancellation, got %v", others)
+		}
+		if cancelled, ok := others[0].(OrderCancelled); !ok || cancelled.OrderID != 10 || cancelled.Quantity != 6 {
+			t.Errorf("Expected 6 of order 10 to be cancelled, got %v", others[0])
+		}
+	})
+
+	t.Run("should work out a wide protection limit without stepping tick by tick", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: 1})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100, Quantity: 5})
+		order := &Order{ID: 10, Type: "market", Side: "buy", Quantity: 1, ProtectionTicks: maxProtectionTicks}
+		me.PlaceOrder(order)
+		sell := &Order{ID: 11, Type: "market", Side: "sell", Quantity: 1, ProtectionTicks: maxProtectionTicks}
+		me.PlaceOrder(sell)
+
+		if order.Price != 100*PricePrecision+maxProtectionTicks {
+			t.Errorf("Expected a limit of %d, got %d", 100*PricePrecision+maxProtectionTicks, order.Price)
+		}
+		if sell.Price != 1 {
+			t.Errorf("Expected the sell limit to stop at the lowest tick, got %d", sell.Price)
+		}
+		if trades, _ := popReports(outputBuffer); len(trades) != 2 {
+			t.Errorf("Expected both orders to trade, got %v", trades)
+		}
+	})
+
+	t.Run("should clamp a protection limit to the maximum price", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: PricePrecision, MaxPrice: 150 * PricePrecision})
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+		order := &Order{ID: 10, Type: "market", Side: "buy", Quantity: 1, ProtectionTicks: maxProtectionTicks}
+		me.PlaceOrder(order)
+
+		if order.Price != 150*PricePrecision {
+			t.Errorf("Expected a limit of %d, got %d", 150*PricePrecision, order.Price)
+		}
+	})
+
+	t.Run("should reject invalid protection", func(t *testing.T) {
+		tests := []*Order{
+			{ID: 10, Type: "market", Side: "buy", Quantity: 5, ProtectionTicks: -1},
+			{ID: 11, Type: "market", Side: "buy", Quantity: 5, ProtectionTicks: 1, ProtectionBasisPoints: 100},
+			{ID: 12, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 5, ProtectionTicks: 1},
+			{ID: 13, Type: "market", Side: "buy", Quantity: 1, ProtectionTicks: 1 << 40},
+		}
+		for _, order := range tests {
+			me, outputBuffer := setup()
+			me.PlaceOrder(order)
+
+			event, _ := outputBuffer.Pop()
+			if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectInvalidProtection {
+				t.Errorf("Expected order %d to be rejected for invalid protection, got %v", order.ID, event.Report)
+			}
+		}
+	})
+}
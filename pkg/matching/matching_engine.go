@@ -1,17 +1,42 @@
 package matching
 
 import (
-	"container/heap"
 	"fmt"
 )
 
 type Order struct {
 	ID        int
 	OrdererID int
-	Type      string // "market", "limit", "stop-loss", "post-only", "aon", "fok", "ioc"
+	Symbol    string // Instrument identifier, e.g. "BTC-USD".
+	Type      string // "market", "market-to-limit", "limit", "stop-loss", "stop-limit", "trailing-stop", "post-only", "aon", "fok", "ioc"
 	Side      string // "buy", "sell"
-	Price     int64
+	Price     int64  // Limit price; for a plain stop-loss without TriggerPrice, the stop price.
 	Quantity  int
+	// TriggerPrice is the last-trade price at which a stop order is released.
+	// For a trailing stop the engine keeps it up to date as the market moves.
+	TriggerPrice int64
+	// A trailing stop follows the best price seen since it was placed by
+	// either a fixed TrailingOffset or TrailingBasisPoints of that price.
+	TrailingOffset      int64
+	TrailingBasisPoints int64
+	// DisplayQuantity turns a resting limit order into an iceberg: only this
+	// much is shown on the book, and the rest is held back as a hidden reserve.
+	// An all-or-none order cannot be an iceberg.
+	DisplayQuantity int
+	// PostOnlyMode decides what happens to a post-only order that would cross
+	// the spread: "reject" (the default) or "slide" to the best non-crossing tick.
+	PostOnlyMode string
+	// SelfTradePrevention overrides the book's default STP mode for this order.
+	SelfTradePrevention string
+	// TimeInForce is "gtc" (the default), "day" or "gtd". A "gtd" order
+	// expires at ExpireAt, in engine time (Unix nanoseconds).
+	TimeInForce string
+	ExpireAt    int64
+	// A market order, or the market order a stop releases, stops matching
+	// ProtectionTicks ticks or ProtectionBasisPoints away from the best
+	// opposite price when it arrives. At most one of them may be set.
+	ProtectionTicks       int
+	ProtectionBasisPoints int64
 }
 
 type Trade struct {
@@ -19,69 +44,185 @@ type Trade struct {
 	MakerOrderID int
 	Price        int64
 	Quantity     int
+	// Auction is set on trades from an auction uncross. They have no
+	// aggressor; the buy order is reported as the taker.
+	Auction bool
+	// Fees charged to each side, in the fixed-point units of prices. A
+	// negative fee is a rebate.
+	TakerFee int64
+	MakerFee int64
+}
+
+// A CancelRequest asks the engine to pull a resting limit order or a pending
+// stop order. Only the participant that placed the order may cancel it.
+type CancelRequest struct {
+	Symbol    string
+	OrderID   int
+	OrdererID int
+}
+
+// An AmendRequest changes the price and/or open quantity of a resting order.
+// A zero Price or Quantity leaves that attribute unchanged. Reducing the
+// quantity keeps queue priority; any other change re-enters the order as if
+// it had just arrived.
+type AmendRequest struct {
+	Symbol    string
+	OrderID   int
+	OrdererID int
+	Price     int64
+	Quantity  int
 }
 
-// A StopLossOrder is something we manage in a priority queue.
-type StopLossOrder struct {
-	value    *Order // The value of the item; arbitrary.
-	priority int64  // The priority of the item in the queue.
-	// The index is needed by update and is maintained by the heap.Interface methods.
-	index int // The index of the item in the heap.
+const (
+	RejectUnknownOrder       = "unknown-order"
+	RejectNotOwner           = "not-owner"
+	RejectInvalidQuantity    = "invalid-quantity"
+	RejectPostOnlyWouldCross = "post-only-would-cross"
+	RejectInvalidTrigger     = "invalid-trigger-price"
+	RejectInvalidExpiry      = "invalid-expiry"
+	RejectUnknownInstrument  = "unknown-instrument"
+
+	RejectInvalidSide          = "invalid-side"
+	RejectInvalidType          = "invalid-order-type"
+	RejectInvalidPrice         = "invalid-price"
+	RejectInvalidTickSize      = "invalid-tick-size"
+	RejectInvalidLotSize       = "invalid-lot-size"
+	RejectQuantityBelowMinimum = "quantity-below-minimum"
+	RejectQuantityAboveMaximum = "quantity-above-maximum"
+	RejectPriceOutOfRange      = "price-out-of-range"
+	RejectDuplicateOrderID     = "duplicate-order-id"
+	RejectBelowMinNotional     = "below-min-notional"
+	RejectPriceOutsideBand     = "price-outside-band"
+	RejectInstrumentHalted     = "instrument-halted"
+	RejectNotAllowedInAuction  = "not-allowed-in-auction"
+	RejectInvalidDisplay       = "invalid-display-quantity"
+)
+
+// OrderRejected is published when a new order is refused before it reaches
+// the book.
+type OrderRejected struct {
+	OrderID   int
+	OrdererID int
+	Reason    string
 }
 
-// A StopLossQueue implements heap.Interface and holds StopLossOrders.
-type StopLossQueue []*StopLossOrder
+// OrderPriceAdjusted is published when the engine rests an order at a price
+// other than the one requested, e.g. a sliding post-only order.
+type OrderPriceAdjusted struct {
+	OrderID   int
+	OrdererID int
+	OldPrice  int64
+	Price     int64
+}
 
-func (pq StopLossQueue) Len() int { return len(pq) }
+const (
+	CancelReasonRequested   = "requested"
+	CancelReasonIOCUnfilled = "ioc-unfilled"
+	CancelReasonFOKUnfilled = "fok-unfilled"
+)
 
-func (pq StopLossQueue) Less(i, j int) bool {
-	// We want Pop to give us the highest, not lowest, priority so we use greater than here.
-	return pq[i].priority > pq[j].priority
+// OrderCancelled is published when an order has been pulled, either at its
+// owner's request or by the engine. Quantity is the open quantity that was
+// removed.
+type OrderCancelled struct {
+	OrderID   int
+	OrdererID int
+	Side      string
+	Price     int64
+	Quantity  int
+	Reason    string
 }
 
-func (pq StopLossQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
-	pq[i].index = i
-	pq[j].index = j
+// CancelRejected is published when a CancelRequest could not be honoured.
+type CancelRejected struct {
+	OrderID   int
+	OrdererID int
+	Reason    string
 }
 
-func (pq *StopLossQueue) Push(x interface{}) {
-	n := len(*pq)
-	item := x.(*StopLossOrder)
-	item.index = n
-	*pq = append(*pq, item)
+// OrderReplaced is published when an amend has been applied. PriorityKept
+// reports whether the order kept its place in the queue; if not, any trades
+// from re-entering the book follow this event.
+type OrderReplaced struct {
+	OrderID      int
+	OrdererID    int
+	Side         string
+	OldPrice     int64
+	OldQuantity  int
+	Price        int64
+	Quantity     int
+	PriorityKept bool
 }
 
-func (pq *StopLossQueue) Pop() interface{} {
-	old := *pq
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil  // avoid memory leak
-	item.index = -1 // for safety
-	*pq = old[0 : n-1]
-	return item
+// AmendRejected is published when an AmendRequest could not be honoured.
+type AmendRejected struct {
+	OrderID   int
+	OrdererID int
+	Reason    string
 }
 
+// DefaultSymbol is the instrument that single-instrument engines trade.
+const DefaultSymbol = ""
+
+// A MatchingEngine hosts one order book per instrument and routes every
+// command to the book named by its Symbol. Engine time is shared by all of
+// them.
 type MatchingEngine struct {
-	orderBook      *OrderBook
-	buyStopOrders  *StopLossQueue
-	sellStopOrders *StopLossQueue
-	inputBuffer    *RingBuffer
-	outputBuffer   *RingBuffer
+	instruments  map[string]*instrument
+	symbols      []string // Registration order, so every pass over instruments is deterministic.
+	now          int64    // Engine time, advanced only by ClockTick commands.
+	feeTiers     map[int]string
+	inputBuffer  *RingBuffer
+	outputBuffer *RingBuffer
+
+	// inputSequence numbers every command the engine processes, rejected
+	// ones included; outputSequence numbers every event it publishes.
+	inputSequence  uint64
+	outputSequence uint64
+	replaying      bool
+	snapshots      *snapshotScheduler
 }
 
 func NewMatchingEngine(outputBuffer *RingBuffer) *MatchingEngine {
-	buyStopOrders := &StopLossQueue{}
-	sellStopOrders := &StopLossQueue{}
-	heap.Init(buyStopOrders)
-	heap.Init(sellStopOrders)
-	return &MatchingEngine{
-		orderBook:      NewOrderBook(&OrderBookConfig{MinTickSize: 1}),
-		buyStopOrders:  buyStopOrders,
-		sellStopOrders: sellStopOrders,
-		inputBuffer:    NewRingBuffer(1024),
-		outputBuffer:   outputBuffer,
+	return NewMatchingEngineWithConfig(outputBuffer, &OrderBookConfig{MinTickSize: 1})
+}
+
+// NewMatchingEngineWithConfig creates an engine with a single instrument
+// described by config.
+func NewMatchingEngineWithConfig(outputBuffer *RingBuffer, config *OrderBookConfig) *MatchingEngine {
+	return NewMultiInstrumentEngine(outputBuffer, []*OrderBookConfig{config})
+}
+
+// NewMultiInstrumentEngine creates an engine with one order book per config,
+// keyed by OrderBookConfig.Symbol. It panics on duplicate symbols, as that is
+// a configuration error.
+func NewMultiInstrumentEngine(outputBuffer *RingBuffer, configs []*OrderBookConfig) *MatchingEngine {
+	me := &MatchingEngine{
+		instruments:  make(map[string]*instrument),
+		feeTiers:     make(map[int]string),
+		inputBuffer:  NewRingBuffer(1024),
+		outputBuffer: outputBuffer,
+	}
+	for _, config := range configs {
+		if err := me.AddInstrument(config); err != nil {
+			panic(err)
+		}
+	}
+	return me
+}
+
+// AddInstrument registers a new order book. It must not be called while Run
+// is processing commands.
+func (me *MatchingEngine) AddInstrument(config *OrderBookConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
 	}
+	if _, ok := me.instruments[config.Symbol]; ok {
+		return fmt.Errorf("instrument already registered: %q", config.Symbol)
+	}
+	me.instruments[config.Symbol] = newInstrument(me, config)
+	me.symbols = append(me.symbols, config.Symbol)
+	return nil
 }
 
 func (me *MatchingEngine) Run() {
@@ -90,7 +231,33 @@ func (me *MatchingEngine) Run() {
 		if !ok {
 			continue
 		}
+		me.apply(event)
+	}
+}
+
+// Replay applies a command recovered from the input log after a restart. It
+// is processed like any other command, but the events it publishes are
+// marked Replayed, as they were published before the engine went down.
+func (me *MatchingEngine) Replay(command Event) {
+	me.replaying = true
+	me.apply(command)
+	me.replaying = false
+}
+
+func (me *MatchingEngine) apply(event Event) {
+	if event.Order != nil {
 		me.PlaceOrder(event.Order)
+		return
+	}
+	switch cmd := event.Data.(type) {
+	case *CancelRequest:
+		me.CancelOrder(cmd)
+	case *AmendRequest:
+		me.AmendOrder(cmd)
+	case *ClockTick:
+		me.AdvanceClock(cmd)
+	case *PhaseChange:
+		me.ChangePhase(cmd)
 	}
 }
 
@@ -102,159 +269,736 @@ func (me *MatchingEngine) PlaceOrders(orders []*Order) {
 	}
 }
 
+func (me *MatchingEngine) CancelOrders(cancels []*CancelRequest) {
+	for _, cancel := range cancels {
+		for !me.inputBuffer.Push(Event{Data: cancel}) {
+			// Keep trying until the push is successful
+		}
+	}
+}
+
+func (me *MatchingEngine) AmendOrders(amends []*AmendRequest) {
+	for _, amend := range amends {
+		for !me.inputBuffer.Push(Event{Data: amend}) {
+			// Keep trying until the push is successful
+		}
+	}
+}
+
+func (me *MatchingEngine) SubmitClockTick(tick *ClockTick) {
+	for !me.inputBuffer.Push(Event{Data: tick}) {
+		// Keep trying until the push is successful
+	}
+}
+
+func (me *MatchingEngine) SubmitPhaseChange(change *PhaseChange) {
+	for !me.inputBuffer.Push(Event{Data: change}) {
+		// Keep trying until the push is successful
+	}
+}
+
+// PlaceOrder routes an order to its instrument. Orders for instruments the
+// engine does not trade are rejected.
 func (me *MatchingEngine) PlaceOrder(order *Order) {
-	if order.Type == "stop-loss" {
-		item := &StopLossOrder{
-			value:    order,
-			priority: order.Price,
+	me.inputSequence++
+	defer me.snapshotIfDue()
+	in, ok := me.instruments[order.Symbol]
+	if !ok {
+		me.publish(order.Symbol, OrderRejected{
+			OrderID:   order.ID,
+			OrdererID: order.OrdererID,
+			Reason:    RejectUnknownInstrument,
+		})
+		return
+	}
+	in.placeOrder(order)
+	in.publishIndicative()
+}
+
+// CancelOrder removes a resting limit order or a pending stop order on behalf
+// of its owner and publishes either an OrderCancelled or a CancelRejected event.
+func (me *MatchingEngine) CancelOrder(cancel *CancelRequest) {
+	me.inputSequence++
+	defer me.snapshotIfDue()
+	in, ok := me.instruments[cancel.Symbol]
+	if !ok {
+		me.publish(cancel.Symbol, CancelRejected{
+			OrderID:   cancel.OrderID,
+			OrdererID: cancel.OrdererID,
+			Reason:    RejectUnknownInstrument,
+		})
+		return
+	}
+	in.cancelOrder(cancel)
+	in.publishIndicative()
+}
+
+// AmendOrder changes a resting order on behalf of its owner. A pure quantity
+// decrease is applied in place; a price change or quantity increase pulls the
+// order and runs it through matchLimitOrder again, so a repriced order that
+// crosses the spread trades immediately. A post-only order is instead slid or
+// rejected by the same rules as when it was placed.
+func (me *MatchingEngine) AmendOrder(amend *AmendRequest) {
+	me.inputSequence++
+	defer me.snapshotIfDue()
+	in, ok := me.instruments[amend.Symbol]
+	if !ok {
+		me.publish(amend.Symbol, AmendRejected{
+			OrderID:   amend.OrderID,
+			OrdererID: amend.OrdererID,
+			Reason:    RejectUnknownInstrument,
+		})
+		return
+	}
+	in.amendOrder(amend)
+	in.publishIndicative()
+}
+
+// ChangePhase moves an instrument into another trading phase: a call
+// auction, continuous trading (uncrossing a running auction) or a halt.
+func (me *MatchingEngine) ChangePhase(change *PhaseChange) {
+	me.inputSequence++
+	defer me.snapshotIfDue()
+	in, ok := me.instruments[change.Symbol]
+	if !ok {
+		me.publish(change.Symbol, PhaseChangeRejected{
+			Phase:  change.Phase,
+			Reason: RejectUnknownInstrument,
+		})
+		return
+	}
+	in.changePhase(change)
+}
+
+// TradingPhase returns the phase an instrument is in, or "" if the engine
+// does not trade it.
+func (me *MatchingEngine) TradingPhase(symbol string) string {
+	in, ok := me.instruments[symbol]
+	if !ok {
+		return ""
+	}
+	return in.phase
+}
+
+// AdvanceClock moves engine time to tick.Time and, instrument by instrument,
+// expires every order that is due and ends any halt or auction whose time is
+// up. Ticks that would move time backwards are ignored.
+func (me *MatchingEngine) AdvanceClock(tick *ClockTick) {
+	me.inputSequence++
+	defer me.snapshotIfDue()
+	if tick.Time <= me.now {
+		return
+	}
+	me.now = tick.Time
+	for _, symbol := range me.symbols {
+		in := me.instruments[symbol]
+		in.expireOrders(me.now)
+		in.advancePhase(me.now)
+		in.publishIndicative()
+	}
+}
+
+func (me *MatchingEngine) TakeSnapshot() {
+	for _, symbol := range me.symbols {
+		me.instruments[symbol].takeSnapshot()
+	}
+}
+
+func (in *instrument) placeOrder(order *Order) {
+	switch in.phase {
+	case PhaseHalted:
+		in.rejectOrder(order, RejectInstrumentHalted)
+		return
+	case PhaseAuction:
+		if !allowedInAuction(order.Type) {
+			in.rejectOrder(order, RejectNotAllowedInAuction)
+			return
 		}
-		if order.Side == "buy" {
-			heap.Push(me.buyStopOrders, item)
+	}
+	if reason := in.validateOrder(order); reason != "" {
+		in.rejectOrder(order, reason)
+		return
+	}
+	if reason := in.validateExpiry(order); reason != "" {
+		in.rejectOrder(order, reason)
+		return
+	}
+
+	if order.Type == "stop-loss" || order.Type == "stop-limit" || order.Type == "trailing-stop" {
+		in.addStopOrder(order)
+		// A stop whose trigger the last trade has already reached fires at once.
+		in.triggerStopLossOrders()
+		return
+	}
+
+	if !isMarketType(order.Type) && !in.withinPriceBand(order.Price) {
+		in.rejectOrder(order, RejectPriceOutsideBand)
+		return
+	}
+	if order.Type == "post-only" && !in.preparePostOnlyOrder(order) {
+		return
+	}
+	in.accept(order)
+	in.scheduleExpiry(order)
+
+	switch order.Type {
+	case "market", "market-to-limit":
+		in.matchMarketOrder(order)
+	case "ioc":
+		in.crossLimitOrder(order)
+		in.cancelRemainder(order, CancelReasonIOCUnfilled)
+	case "fok":
+		if in.canFill(order) {
+			in.fillOrder(order, true)
 		} else {
-			item.priority = -order.Price
-			heap.Push(me.sellStopOrders, item)
+			in.cancelRemainder(order, CancelReasonFOKUnfilled)
 		}
+	default:
+		in.matchLimitOrder(order)
+	}
+	in.triggerStopLossOrders()
+}
+
+// cancelRemainder reports the unfilled part of an order that is not allowed
+// to rest on the book.
+func (in *instrument) cancelRemainder(order *Order, reason string) {
+	if order.Quantity <= 0 {
 		return
 	}
+	in.publish(OrderCancelled{
+		OrderID:   order.ID,
+		OrdererID: order.OrdererID,
+		Side:      order.Side,
+		Price:     order.Price,
+		Quantity:  order.Quantity,
+		Reason:    reason,
+	})
+}
+
+// preparePostOnlyOrder makes sure a post-only order can only add liquidity.
+// An order that would cross the opposite best price is either rejected or,
+// in "slide" mode, repriced one tick inside the spread. It reports whether the
+// order should go on to the book.
+func (in *instrument) preparePostOnlyOrder(order *Order) bool {
+	price := in.orderBook.roundPrice(order.Price)
+	restingPrice, ok := in.postOnlyPrice(order.Side, price, order.PostOnlyMode)
+	if !ok {
+		in.rejectOrder(order, RejectPostOnlyWouldCross)
+		return false
+	}
 
-	if order.Type == "market" {
-		me.matchMarketOrder(order)
+	if restingPrice != price {
+		in.publish(OrderPriceAdjusted{
+			OrderID:   order.ID,
+			OrdererID: order.OrdererID,
+			OldPrice:  order.Price,
+			Price:     restingPrice,
+		})
+	}
+	order.Price = restingPrice
+	return true
+}
+
+// postOnlyPrice returns the price a post-only order can rest at without
+// taking liquidity: price itself if it does not cross the opposite best
+// price, or in "slide" mode the best tick that does not. It reports false if
+// the order has to be rejected instead.
+func (in *instrument) postOnlyPrice(side string, price int64, mode string) (int64, bool) {
+	var slidPrice int64
+	if side == "buy" {
+		bestAsk := in.orderBook.BestAsk()
+		if bestAsk == nil || price < bestAsk.Price {
+			return price, true
+		}
+		slidPrice = in.orderBook.roundPrice(bestAsk.Price - 1)
 	} else {
-		me.matchLimitOrder(order)
+		bestBid := in.orderBook.BestBid()
+		if bestBid == nil || price > bestBid.Price {
+			return price, true
+		}
+		slidPrice = bestBid.Price + in.orderBook.config.TickSize(bestBid.Price)
 	}
-	me.triggerStopLossOrders(order.Price)
+
+	if mode != "slide" || slidPrice <= 0 {
+		return 0, false
+	}
+	return slidPrice, true
 }
 
-func (me *MatchingEngine) triggerStopLossOrders(currentPrice int64) {
-	// Trigger sell stop-loss orders
-	for me.sellStopOrders.Len() > 0 && -(*me.sellStopOrders)[0].priority <= currentPrice {
-		slOrder := heap.Pop(me.sellStopOrders).(*StopLossOrder).value
-		marketOrder := &Order{
-			ID:        slOrder.ID,
-			OrdererID: slOrder.OrdererID,
-			Type:      "market",
-			Side:      slOrder.Side,
-			Quantity:  slOrder.Quantity,
+func (in *instrument) cancelOrder(cancel *CancelRequest) {
+	if bookOrder := in.orderBook.GetOrder(cancel.OrderID); bookOrder != nil {
+		if bookOrder.OrdererID != cancel.OrdererID {
+			in.rejectCancel(cancel, RejectNotOwner)
+			return
 		}
-		me.matchMarketOrder(marketOrder)
+		in.orderBook.RemoveOrder(bookOrder.ID)
+		in.publish(OrderCancelled{
+			OrderID:   bookOrder.ID,
+			OrdererID: bookOrder.OrdererID,
+			Side:      bookOrder.Side,
+			Price:     bookOrder.Price,
+			Quantity:  bookOrder.openQuantity(),
+			Reason:    CancelReasonRequested,
+		})
+		return
 	}
 
-	// Trigger buy stop-loss orders
-	for me.buyStopOrders.Len() > 0 && (*me.buyStopOrders)[0].priority >= currentPrice {
-		slOrder := heap.Pop(me.buyStopOrders).(*StopLossOrder).value
-		marketOrder := &Order{
-			ID:        slOrder.ID,
-			OrdererID: slOrder.OrdererID,
-			Type:      "market",
-			Side:      slOrder.Side,
-			Quantity:  slOrder.Quantity,
+	if item, ok := in.stopOrders[cancel.OrderID]; ok {
+		stopOrder := item.value
+		if stopOrder.OrdererID != cancel.OrdererID {
+			in.rejectCancel(cancel, RejectNotOwner)
+			return
 		}
-		me.matchMarketOrder(marketOrder)
+		in.removeStopOrder(item)
+		in.publish(OrderCancelled{
+			OrderID:   stopOrder.ID,
+			OrdererID: stopOrder.OrdererID,
+			Side:      stopOrder.Side,
+			Price:     stopOrder.Price,
+			Quantity:  stopOrder.Quantity,
+			Reason:    CancelReasonRequested,
+		})
+		return
 	}
+
+	in.rejectCancel(cancel, RejectUnknownOrder)
 }
 
-func (me *MatchingEngine) matchMarketOrder(order *Order) {
-	if order.Side == "buy" {
-		for order.Quantity > 0 && me.orderBook.BestAsk() != nil {
-			bestAsk := me.orderBook.BestAsk()
-			if order.Quantity >= bestAsk.Quantity {
-				me.executeTrade(order, bestAsk, bestAsk.Price)
-				order.Quantity -= bestAsk.Quantity
-				me.orderBook.RemoveOrder(bestAsk.ID)
-			} else {
-				me.executeTrade(order, bestAsk, bestAsk.Price)
-				bestAsk.Quantity -= order.Quantity
-				order.Quantity = 0
-			}
+func (in *instrument) rejectCancel(cancel *CancelRequest, reason string) {
+	in.publish(CancelRejected{
+		OrderID:   cancel.OrderID,
+		OrdererID: cancel.OrdererID,
+		Reason:    reason,
+	})
+}
+
+func (in *instrument) amendOrder(amend *AmendRequest) {
+	bookOrder := in.orderBook.GetOrder(amend.OrderID)
+	if bookOrder == nil {
+		in.rejectAmend(amend, RejectUnknownOrder)
+		return
+	}
+	if bookOrder.OrdererID != amend.OrdererID {
+		in.rejectAmend(amend, RejectNotOwner)
+		return
+	}
+	if in.phase == PhaseHalted {
+		in.rejectAmend(amend, RejectInstrumentHalted)
+		return
+	}
+	if amend.Quantity < 0 || amend.Price < 0 {
+		in.rejectAmend(amend, RejectInvalidQuantity)
+		return
+	}
+
+	price := bookOrder.Price
+	if amend.Price != 0 {
+		if reason := in.validatePrice(amend.Price); reason != "" {
+			in.rejectAmend(amend, reason)
+			return
+		}
+		if !in.withinPriceBand(amend.Price) {
+			in.rejectAmend(amend, RejectPriceOutsideBand)
+			return
+		}
+		price = amend.Price
+	}
+	openQuantity := bookOrder.openQuantity()
+	quantity := openQuantity
+	if amend.Quantity != 0 {
+		if reason := in.validateQuantity(amend.Quantity); reason != "" {
+			in.rejectAmend(amend, reason)
+			return
+		}
+		quantity = amend.Quantity
+	}
+	if reason := in.validateNotional(price, quantity); reason != "" {
+		in.rejectAmend(amend, reason)
+		return
+	}
+
+	replaced := OrderReplaced{
+		OrderID:     bookOrder.ID,
+		OrdererID:   bookOrder.OrdererID,
+		Side:        bookOrder.Side,
+		OldPrice:    bookOrder.Price,
+		OldQuantity: openQuantity,
+		Price:       price,
+		Quantity:    quantity,
+	}
+
+	if price == bookOrder.Price && quantity <= openQuantity {
+		in.orderBook.Reduce(bookOrder.ID, quantity)
+		replaced.PriorityKept = true
+		in.publish(replaced)
+		return
+	}
+
+	// A post-only order re-enters under the same rules as when it was
+	// placed, so an amend cannot turn it into a taker.
+	if bookOrder.PostOnly {
+		restingPrice, ok := in.postOnlyPrice(bookOrder.Side, price, bookOrder.postOnlyMode)
+		if !ok {
+			in.rejectAmend(amend, RejectPostOnlyWouldCross)
+			return
+		}
+		if restingPrice != price {
+			in.publish(OrderPriceAdjusted{
+				OrderID:   bookOrder.ID,
+				OrdererID: bookOrder.OrdererID,
+				OldPrice:  price,
+				Price:     restingPrice,
+			})
+			price = restingPrice
+			replaced.Price = price
+		}
+	}
+
+	in.orderBook.RemoveOrder(bookOrder.ID)
+	orderType := "limit"
+	switch {
+	case bookOrder.AllOrNone:
+		orderType = "aon"
+	case bookOrder.PostOnly:
+		orderType = "post-only"
+	}
+	reentered := &Order{
+		ID:                  bookOrder.ID,
+		OrdererID:           bookOrder.OrdererID,
+		Type:                orderType,
+		Side:                bookOrder.Side,
+		Price:               price,
+		Quantity:            quantity,
+		DisplayQuantity:     bookOrder.peak,
+		PostOnlyMode:        bookOrder.postOnlyMode,
+		SelfTradePrevention: bookOrder.selfTradePrevention,
+	}
+	if bookOrder.expireAt > 0 {
+		// The order's expiry entry is still queued under its ID.
+		reentered.TimeInForce, reentered.ExpireAt = "gtd", bookOrder.expireAt
+	}
+	in.publish(replaced)
+	in.matchLimitOrder(reentered)
+	in.triggerStopLossOrders()
+}
+
+func (in *instrument) rejectAmend(amend *AmendRequest, reason string) {
+	in.publish(AmendRejected{
+		OrderID:   amend.OrderID,
+		OrdererID: amend.OrdererID,
+		Reason:    reason,
+	})
+}
+
+func (in *instrument) matchLimitOrder(order *Order) {
+	if in.phase == PhaseAuction {
+		// Orders only collect on the book until the auction uncrosses.
+	} else if order.Type == "aon" {
+		// An all-or-none order only trades if it can be filled completely
+		// right now; otherwise it waits on the book for enough liquidity.
+		if in.canFill(order) {
+			in.fillOrder(order, true)
+		}
+	} else {
+		in.crossLimitOrder(order)
+	}
+	in.restOrder(order)
+}
+
+// restOrder puts what is left of an order on the book.
+func (in *instrument) restOrder(order *Order) {
+	if order.Quantity > 0 {
+		bookOrder := &BookOrder{
+			ID:                  order.ID,
+			OrdererID:           order.OrdererID,
+			Side:                order.Side,
+			Price:               order.Price,
+			Quantity:            order.Quantity,
+			AllOrNone:           order.Type == "aon",
+			PostOnly:            order.Type == "post-only",
+			selfTradePrevention: order.SelfTradePrevention,
+			expireAt:            expiresAt(order),
+		}
+		if bookOrder.PostOnly {
+			bookOrder.postOnlyMode = order.PostOnlyMode
+		}
+		if order.DisplayQuantity > 0 && order.DisplayQuantity < order.Quantity {
+			bookOrder.Quantity = order.DisplayQuantity
+			bookOrder.peak = order.DisplayQuantity
+			bookOrder.reserve = order.Quantity - order.DisplayQuantity
 		}
-	} else { // order.Side == "sell"
-		for order.Quantity > 0 && me.orderBook.BestBid() != nil {
-			bestBid := me.orderBook.BestBid()
-			if order.Quantity >= bestBid.Quantity {
-				me.executeTrade(order, bestBid, bestBid.Price)
-				order.Quantity -= bestBid.Quantity
-				me.orderBook.RemoveOrder(bestBid.ID)
-			} else {
-				me.executeTrade(order, bestBid, bestBid.Price)
-				bestBid.Quantity -= order.Quantity
-				order.Quantity = 0
+		in.orderBook.AddOrder(bookOrder)
+	}
+}
+
+// crossLimitOrder matches an order against the opposite side of the book up
+// to its limit price and leaves any remainder in order.Quantity.
+func (in *instrument) crossLimitOrder(order *Order) {
+	in.fillOrder(order, true)
+}
+
+// fillOrder matches an order for as long as there is liquidity it can take.
+// Planning only sees displayed quantity, so when an iceberg is replenished
+// during execution the book is planned again to pick up the new peak. It
+// returns the price of the order's last trade, or 0 if it did not trade.
+func (in *instrument) fillOrder(order *Order, limited bool) int64 {
+	var lastPrice int64
+	for {
+		fills, _ := in.planFills(order, limited)
+		for _, f := range fills {
+			if !f.selfTrade {
+				lastPrice = f.maker.Price
 			}
 		}
+		if !in.executeFills(order, fills) || order.Quantity == 0 {
+			return lastPrice
+		}
 	}
 }
 
-func (me *MatchingEngine) matchLimitOrder(order *Order) {
-	if order.Side == "buy" {
-		for order.Quantity > 0 && me.orderBook.BestAsk() != nil && order.Price >= me.orderBook.BestAsk().Price {
-			bestAsk := me.orderBook.BestAsk()
-			if order.Quantity >= bestAsk.Quantity {
-				me.executeTrade(order, bestAsk, bestAsk.Price)
-				order.Quantity -= bestAsk.Quantity
-				me.orderBook.RemoveOrder(bestAsk.ID)
-			} else {
-				me.executeTrade(order, bestAsk, bestAsk.Price)
-				bestAsk.Quantity -= order.Quantity
-				order.Quantity = 0
+// canFill reports whether the book can fill all of an FOK or all-or-none
+// order right now, within its limit price and the price band. It counts the
+// open quantity of each maker, iceberg reserves included, since fillOrder
+// keeps taking replenished peaks until the order is done. All-or-none makers
+// are only counted where planFills would fill them: under FIFO when they fit
+// in what is left, and never under a policy that shares the level, which may
+// pass them over. A maker of the same orderer is never counted, and unless
+// self-trade prevention only cancels the maker, meeting one cuts the taker
+// short, so the count stops there.
+func (in *instrument) canFill(order *Order) bool {
+	contraSide := "sell"
+	if order.Side == "sell" {
+		contraSide = "buy"
+	}
+	stpMode := in.selfTradePreventionMode(order)
+	bandLow, bandHigh, banded := in.priceBand()
+
+	remaining := order.Quantity
+	in.orderBook.walk(contraSide, func(maker *BookOrder) bool {
+		if !crosses(order, maker.Price) {
+			return false
+		}
+		if banded && (maker.Price < bandLow || maker.Price > bandHigh) {
+			return false
+		}
+		if stpMode != SelfTradeAllow && maker.OrdererID == order.OrdererID {
+			return stpMode == SelfTradeCancelOldest
+		}
+		if maker.AllOrNone && (in.policy.SharesLevel() || maker.Quantity > remaining) {
+			return true
+		}
+		remaining -= min(maker.openQuantity(), remaining)
+		return remaining > 0
+	})
+	return remaining == 0
+}
+
+// A fill is one planned execution against a resting order. A fill with
+// selfTrade set is not a trade: the maker belongs to the taker's orderer and
+// the match is resolved by preventSelfTrade instead.
+type fill struct {
+	maker     *BookOrder
+	quantity  int
+	selfTrade bool
+}
+
+// planFills walks the opposite side of the book best price first and works
+// out which resting orders the incoming order would trade with, without
+// changing the book. Limited orders stop at their limit price. The
+// instrument's matching policy shares the quantity among the orders at each
+// price level; all-or-none makers that cannot be filled completely are left
+// out, so they never block the orders queued behind them. Makers owned by the
+// same orderer are planned as self-trade steps when self-trade prevention is
+// on. Under FIFO the orders ahead of such a maker at its level are filled
+// first; a policy that shares the level resolves all of the level's
+// self-trades first and shares what is left among the other makers. It
+// returns the fills and the total quantity they trade. Only displayed
+// quantity is planned; fillOrder plans again after an iceberg is replenished.
+func (in *instrument) planFills(order *Order, limited bool) ([]fill, int) {
+	contraSide := "sell"
+	if order.Side == "sell" {
+		contraSide = "buy"
+	}
+	stpMode := in.selfTradePreventionMode(order)
+	bandLow, bandHigh, banded := in.priceBand()
+
+	var fills []fill
+	remaining := order.Quantity
+	traded := 0
+
+	// batch collects the makers at the current level that the policy has
+	// yet to allocate; batchQuantity is what its non-AON makers show.
+	batch := in.levelMakers[:0]
+	batchQuantity := 0
+	allocate := func() {
+		if len(batch) == 0 {
+			return
+		}
+		allocations := in.allocations[:0]
+		for range batch {
+			allocations = append(allocations, 0)
+		}
+		in.policy.Allocate(remaining, batch, allocations)
+		for i, maker := range batch {
+			if allocations[i] > 0 {
+				fills = append(fills, fill{maker: maker, quantity: allocations[i]})
+				remaining -= allocations[i]
+				traded += allocations[i]
 			}
 		}
-	} else { // order.Side == "sell"
-		for order.Quantity > 0 && me.orderBook.BestBid() != nil && order.Price <= me.orderBook.BestBid().Price {
-			bestBid := me.orderBook.BestBid()
-			if order.Quantity >= bestBid.Quantity {
-				me.executeTrade(order, bestBid, bestBid.Price)
-				order.Quantity -= bestBid.Quantity
-				me.orderBook.RemoveOrder(bestBid.ID)
-			} else {
-				me.executeTrade(order, bestBid, bestBid.Price)
-				bestBid.Quantity -= order.Quantity
-				order.Quantity = 0
+		in.allocations = allocations
+		batch = batch[:0]
+		batchQuantity = 0
+	}
+
+	in.orderBook.walk(contraSide, func(maker *BookOrder) bool {
+		if len(batch) > 0 && maker.Price != batch[0].Price {
+			allocate()
+		}
+		if remaining == 0 {
+			return false
+		}
+		if limited && !crosses(order, maker.Price) {
+			return false
+		}
+		if banded && (maker.Price < bandLow || maker.Price > bandHigh) {
+			return false
+		}
+		if stpMode != SelfTradeAllow && maker.OrdererID == order.OrdererID {
+			// In time priority the orders ahead of the self-match come
+			// first; a shared level is allocated once its self-matches
+			// are resolved.
+			if !in.policy.SharesLevel() {
+				allocate()
+			}
+			if remaining == 0 {
+				return false
+			}
+			if maker.AllOrNone && maker.Quantity > remaining {
+				return true
 			}
+			fills = append(fills, fill{maker: maker, selfTrade: true})
+			remaining = remainingAfterSelfTrade(stpMode, remaining, maker)
+			return true
 		}
+		batch = append(batch, maker)
+		if !maker.AllOrNone {
+			batchQuantity += maker.Quantity
+		}
+		if !in.policy.SharesLevel() && batchQuantity >= remaining {
+			allocate()
+			return false
+		}
+		return true
+	})
+	allocate()
+	in.levelMakers = batch[:0]
+	return fills, traded
+}
+
+// crosses reports whether an order's limit price allows it to trade at price.
+func crosses(order *Order, price int64) bool {
+	if order.Side == "buy" {
+		return order.Price >= price
 	}
+	return order.Price <= price
+}
 
-	if order.Quantity > 0 {
-		bookOrder := &BookOrder{
-			ID:       order.ID,
-			Side:     order.Side,
-			Price:    order.Price,
-			Quantity: order.Quantity,
+// executeFills carries out planned fills and reports whether any iceberg
+// maker was replenished along the way.
+func (in *instrument) executeFills(order *Order, fills []fill) bool {
+	replenished := false
+	for _, f := range fills {
+		if f.selfTrade {
+			in.preventSelfTrade(order, f.maker)
+			continue
+		}
+		order.Quantity -= f.quantity
+		if in.orderBook.Fill(f.maker.ID, f.quantity) {
+			replenished = true
 		}
-		me.orderBook.AddOrder(bookOrder)
+		in.executeTrade(order, f.maker, f.maker.Price, f.quantity)
 	}
+	return replenished
 }
 
-func (me *MatchingEngine) executeTrade(takerOrder *Order, makerOrder *BookOrder, price int64) {
+// executeTrade reports a trade whose quantity has already been taken off both
+// orders, followed by the fill reports for the taker and the maker.
+func (in *instrument) executeTrade(takerOrder *Order, makerOrder *BookOrder, price int64, quantity int) {
 	trade := Trade{
 		TakerOrderID: takerOrder.ID,
 		MakerOrderID: makerOrder.ID,
 		Price:        price,
-		Quantity:     takerOrder.Quantity,
-	}
-	if takerOrder.Quantity > makerOrder.Quantity {
-		trade.Quantity = makerOrder.Quantity
+		Quantity:     quantity,
 	}
 
-	me.outputBuffer.Push(Event{Data: trade})
-	me.triggerStopLossOrders(price)
+	in.lastTradePrice = price
+	in.recordTrade(price, quantity)
+	in.chargeFees(&trade, takerOrder.OrdererID, makerOrder.OrdererID)
+	in.publish(trade)
+	in.reportFill(takerOrder.ID, takerOrder.OrdererID, takerOrder.Side, price, quantity, takerOrder.Quantity)
+	in.reportFill(makerOrder.ID, makerOrder.OrdererID, makerOrder.Side, price, quantity, makerOrder.openQuantity())
+	in.ratchetTrailingStops(price)
 }
 
-func (me *MatchingEngine) TakeSnapshot() {
-	snapshot := make([]string, 0)
-	for _, item := range *me.orderBook.bids {
-		order := item.value
-		snapshot = append(snapshot, fmt.Sprintf("BID: %d, %d, %d", order.ID, order.Price, order.Quantity))
-	}
-	for _, item := range *me.orderBook.asks {
-		order := item.value
-		snapshot = append(snapshot, fmt.Sprintf("ASK: %d, %d, %d", order.ID, order.Price, order.Quantity))
+func (in *instrument) takeSnapshot() {
+	snapshot := BookSnapshot{Bids: []SnapshotOrder{}, Asks: []SnapshotOrder{}}
+	in.orderBook.walk("buy", func(order *BookOrder) bool {
+		snapshot.Bids = append(snapshot.Bids, SnapshotOrder{OrderID: order.ID, Price: order.Price, Quantity: order.Quantity})
+		return true
+	})
+	in.orderBook.walk("sell", func(order *BookOrder) bool {
+		snapshot.Asks = append(snapshot.Asks, SnapshotOrder{OrderID: order.ID, Price: order.Price, Quantity: order.Quantity})
+		return true
+	})
+	in.publish(snapshot)
+}
+
+// publish pushes an output event tagged with the instrument it belongs to.
+// Engines created without an output buffer drop their events, and so does a
+// full output buffer rather than hold up matching. Either way the event keeps
+// its output sequence number, so consumers can see what they missed.
+func (me *MatchingEngine) publish(symbol string, report ExecutionReport) {
+	me.outputSequence++
+	if me.outputBuffer == nil {
+		return
 	}
-	me.outputBuffer.Push(Event{Data: fmt.Sprintf("SNAPSHOT: %v", snapshot)})
+	me.outputBuffer.Push(Event{
+		Symbol:         symbol,
+		Report:         report,
+		Sequence:       me.inputSequence,
+		OutputSequence: me.outputSequence,
+		Replayed:       me.replaying,
+	})
+}
+
+// InputSequence returns the sequence number of the last command the engine
+// processed.
+func (me *MatchingEngine) InputSequence() uint64 {
+	return me.inputSequence
+}
+
+// OutputSequence returns the sequence number of the last event the engine
+// published.
+func (me *MatchingEngine) OutputSequence() uint64 {
+	return me.outputSequence
 }
 
 func (me *MatchingEngine) GetInputBufferSize() uint64 {
 	return me.inputBuffer.Size()
 }
 
+// GetOrderBook returns the order book of the default instrument.
 func (me *MatchingEngine) GetOrderBook() *OrderBook {
-	return me.orderBook
+	return me.GetInstrumentOrderBook(DefaultSymbol)
+}
+
+// GetInstrumentOrderBook returns the order book for symbol, or nil if the
+// engine does not trade it.
+func (me *MatchingEngine) GetInstrumentOrderBook(symbol string) *OrderBook {
+	in, ok := me.instruments[symbol]
+	if !ok {
+		return nil
+	}
+	return in.orderBook
 }
@@ -22,7 +22,7 @@ func BenchmarkMatchingEngine_PlaceMarketOrder(b *testing.B) {
 	// Pre-fill the order book
 	for i := 0; i < 1000; i++ {
 		order := &BookOrder{ID: i, Side: "sell", Price: int64(100 + i), Quantity: 10}
-		me.orderBook.AddOrder(order)
+		me.GetOrderBook().AddOrder(order)
 	}
 
 	b.ResetTimer()
@@ -44,3 +44,68 @@ func BenchmarkMatchingEngine_PlaceAndMatchOrder(b *testing.B) {
 		me.inputBuffer.Push(Event{Order: sellOrder})
 	}
 }
+
+// newDeepBook returns an engine with levels price levels on each side of a
+// 100.0000 mid price and ordersPerLevel orders on every level.
+func newDeepBook(levels, ordersPerLevel int) *MatchingEngine {
+	me := NewMatchingEngine(nil)
+	id := 0
+	for l := 1; l <= levels; l++ {
+		for o := 0; o < ordersPerLevel; o++ {
+			id++
+			me.PlaceOrder(&Order{ID: id, OrdererID: id, Type: "limit", Side: "sell", Price: 100*PricePrecision + int64(l), Quantity: 10})
+			id++
+			me.PlaceOrder(&Order{ID: id, OrdererID: id, Type: "limit", Side: "buy", Price: 100*PricePrecision - int64(l), Quantity: 10})
+		}
+	}
+	return me
+}
+
+func BenchmarkMatchingEngine_AddCancelDeepBook(b *testing.B) {
+	me := newDeepBook(1000, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := 1_000_000 + i
+		me.PlaceOrder(&Order{ID: id, OrdererID: 1, Type: "limit", Side: "sell", Price: 100*PricePrecision + int64(1+i%1000), Quantity: 10})
+		me.CancelOrder(&CancelRequest{OrderID: id, OrdererID: 1})
+	}
+}
+
+// BenchmarkMatchingEngine_AddCancelDeepLevel opens and empties levels behind
+// the worst price, which is where inserting a level costs the most.
+func BenchmarkMatchingEngine_AddCancelDeepLevel(b *testing.B) {
+	me := newDeepBook(1000, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := 1_000_000 + i
+		me.PlaceOrder(&Order{ID: id, OrdererID: 1, Type: "limit", Side: "sell", Price: 100*PricePrecision + int64(1001+i%1000), Quantity: 10})
+		me.CancelOrder(&CancelRequest{OrderID: id, OrdererID: 1})
+	}
+}
+
+func BenchmarkMatchingEngine_SweepDeepBook(b *testing.B) {
+	me := newDeepBook(1000, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Sweep the 50 best ask levels, then put them back.
+		id := 1_000_000 + i*1000
+		me.PlaceOrder(&Order{ID: id, OrdererID: -1, Type: "market", Side: "buy", Quantity: 50 * 10 * 10})
+		for l := 1; l <= 50; l++ {
+			for o := 0; o < 10; o++ {
+				id++
+				me.PlaceOrder(&Order{ID: id, OrdererID: id, Type: "limit", Side: "sell", Price: 100*PricePrecision + int64(l), Quantity: 10})
+			}
+		}
+	}
+}
+
+func BenchmarkMatchingEngine_BestPriceDeepBook(b *testing.B) {
+	me := newDeepBook(1000, 10)
+	ob := me.GetOrderBook()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if ob.BestBid() == nil || ob.BestAsk() == nil {
+			b.Fatal("Expected a two-sided book")
+		}
+	}
+}
@@ -1,19 +1,20 @@
 package matching
 
 import (
+	"fmt"
 	"testing"
 )
 
 func TestMatchingEngine_PlaceLimitOrder(t *testing.T) {
-	outputBuffer := NewRingBuffer(1024)
-	me := NewMatchingEngine(outputBuffer)
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngine(outputBuffer.buffer)
 
 	t.Run("should place a limit buy order", func(t *testing.T) {
 		buyOrder := &Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10}
 		me.PlaceOrder(buyOrder)
 
-		if me.orderBook.BestBid().Price != 100*PricePrecision {
-			t.Errorf("Expected best bid to be %d, got %d", 100*PricePrecision, me.orderBook.BestBid().Price)
+		if me.GetOrderBook().BestBid().Price != 100*PricePrecision {
+			t.Errorf("Expected best bid to be %d, got %d", 100*PricePrecision, me.GetOrderBook().BestBid().Price)
 		}
 	})
 
@@ -25,20 +26,20 @@ func TestMatchingEngine_PlaceLimitOrder(t *testing.T) {
 		if !ok {
 			t.Fatal("Expected an event, but got none")
 		}
-		if trade, ok := event.Data.(Trade); !ok {
-			t.Errorf("Expected a trade event, but got %v", event.Data)
+		if trade, ok := event.Report.(Trade); !ok {
+			t.Errorf("Expected a trade event, but got %v", event.Report)
 		} else if trade.TakerOrderID != 2 {
 			t.Errorf("Expected taker order ID to be 2, got %d", trade.TakerOrderID)
 		}
-		if me.orderBook.BestBid().Quantity != 5 {
-			t.Errorf("Expected best bid quantity to be 5, got %d", me.orderBook.BestBid().Quantity)
+		if me.GetOrderBook().BestBid().Quantity != 5 {
+			t.Errorf("Expected best bid quantity to be 5, got %d", me.GetOrderBook().BestBid().Quantity)
 		}
 	})
 }
 
 func TestMatchingEngine_PartialFill(t *testing.T) {
-	outputBuffer := NewRingBuffer(1024)
-	me := NewMatchingEngine(outputBuffer)
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngine(outputBuffer.buffer)
 
 	t.Run("should partially fill a limit order", func(t *testing.T) {
 		buyOrder := &Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10}
@@ -51,18 +52,18 @@ func TestMatchingEngine_PartialFill(t *testing.T) {
 		if !ok {
 			t.Fatal("Expected an event, but got none")
 		}
-		if _, ok := event.Data.(Trade); !ok {
-			t.Errorf("Expected a trade event, but got %v", event.Data)
+		if _, ok := event.Report.(Trade); !ok {
+			t.Errorf("Expected a trade event, but got %v", event.Report)
 		}
-		if me.orderBook.BestBid().Quantity != 5 {
-			t.Errorf("Expected best bid quantity to be 5, got %d", me.orderBook.BestBid().Quantity)
+		if me.GetOrderBook().BestBid().Quantity != 5 {
+			t.Errorf("Expected best bid quantity to be 5, got %d", me.GetOrderBook().BestBid().Quantity)
 		}
 	})
 }
 
 func TestMatchingEngine_MultipleFills(t *testing.T) {
-	outputBuffer := NewRingBuffer(1024)
-	me := NewMatchingEngine(outputBuffer)
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngine(outputBuffer.buffer)
 
 	t.Run("should fill an order with multiple trades", func(t *testing.T) {
 		sellOrder1 := &Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5}
@@ -80,31 +81,31 @@ func TestMatchingEngine_MultipleFills(t *testing.T) {
 		if !ok {
 			t.Fatal("Expected an event, but got none")
 		}
-		if _, ok := event.Data.(Trade); !ok {
-			t.Errorf("Expected a trade event, but got %v", event.Data)
+		if _, ok := event.Report.(Trade); !ok {
+			t.Errorf("Expected a trade event, but got %v", event.Report)
 		}
 
 		event, ok = outputBuffer.Pop()
 		if !ok {
 			t.Fatal("Expected an event, but got none")
 		}
-		if _, ok := event.Data.(Trade); !ok {
-			t.Errorf("Expected a trade event, but got %v", event.Data)
+		if _, ok := event.Report.(Trade); !ok {
+			t.Errorf("Expected a trade event, but got %v", event.Report)
 		}
 
-		if me.orderBook.BestAsk() != nil {
-			t.Errorf("Expected order book to be empty, but got %v", me.orderBook.BestAsk())
+		if me.GetOrderBook().BestAsk() != nil {
+			t.Errorf("Expected order book to be empty, but got %v", me.GetOrderBook().BestAsk())
 		}
 	})
 }
 
 func TestMatchingEngine_PlaceMarketOrder(t *testing.T) {
-	outputBuffer := NewRingBuffer(1024)
-	me := NewMatchingEngine(outputBuffer)
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngine(outputBuffer.buffer)
 
 	t.Run("should match a market buy order", func(t *testing.T) {
 		sellOrder := &BookOrder{ID: 1, Side: "sell", Price: 99 * PricePrecision, Quantity: 10}
-		me.orderBook.AddOrder(sellOrder)
+		me.GetOrderBook().AddOrder(sellOrder)
 
 		buyOrder := &Order{ID: 2, Type: "market", Side: "buy", Price: 0, Quantity: 10}
 		me.PlaceOrder(buyOrder)
@@ -113,31 +114,33 @@ func TestMatchingEngine_PlaceMarketOrder(t *testing.T) {
 		if !ok {
 			t.Fatal("Expected an event, but got none")
 		}
-		if _, ok := event.Data.(Trade); !ok {
-			t.Errorf("Expected a trade event, but got %v", event.Data)
+		if _, ok := event.Report.(Trade); !ok {
+			t.Errorf("Expected a trade event, but got %v", event.Report)
 		}
-		if me.orderBook.BestAsk() != nil {
-			t.Errorf("Expected order book to be empty, but got %v", me.orderBook.BestAsk())
+		if me.GetOrderBook().BestAsk() != nil {
+			t.Errorf("Expected order book to be empty, but got %v", me.GetOrderBook().BestAsk())
 		}
 	})
 }
 
 func TestMatchingEngine_PlaceStopLossOrder(t *testing.T) {
-	outputBuffer := NewRingBuffer(1024)
-	me := NewMatchingEngine(outputBuffer)
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngine(outputBuffer.buffer)
 
 	t.Run("should place a stop-loss order", func(t *testing.T) {
 		slOrder := &Order{ID: 1, Type: "stop-loss", Side: "sell", Price: 98 * PricePrecision, Quantity: 5}
 		me.PlaceOrder(slOrder)
 
-		if me.sellStopOrders.Len() != 1 {
-			t.Errorf("Expected 1 stop-loss order, got %d", me.sellStopOrders.Len())
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 1 {
+			t.Errorf("Expected 1 stop-loss order, got %d", me.instruments[DefaultSymbol].sellStopOrders.Len())
 		}
 	})
 
 	t.Run("should trigger a stop-loss order", func(t *testing.T) {
 		buyOrder := &BookOrder{ID: 2, Side: "buy", Price: 98 * PricePrecision, Quantity: 5}
-		me.orderBook.AddOrder(buyOrder)
+		me.GetOrderBook().AddOrder(buyOrder)
+		// Liquidity for the triggered stop to sell into.
+		me.GetOrderBook().AddOrder(&BookOrder{ID: 4, Side: "buy", Price: 97 * PricePrecision, Quantity: 5})
 		sellOrder := &Order{ID: 3, Type: "limit", Side: "sell", Price: 98 * PricePrecision, Quantity: 5}
 		me.PlaceOrder(sellOrder)
 
@@ -148,27 +151,27 @@ func TestMatchingEngine_PlaceStopLossOrder(t *testing.T) {
 		if !ok {
 			t.Fatal("Expected an event, but got none")
 		}
-		if _, ok := event.Data.(Trade); !ok {
-			t.Errorf("Expected a trade event, but got %v", event.Data)
+		if _, ok := event.Report.(Trade); !ok {
+			t.Errorf("Expected a trade event, but got %v", event.Report)
 		}
 
 		event, ok = outputBuffer.Pop()
 		if !ok {
 			t.Fatal("Expected an event, but got none")
 		}
-		if _, ok := event.Data.(Trade); !ok {
-			t.Errorf("Expected a trade event, but got %v", event.Data)
+		if _, ok := event.Report.(Trade); !ok {
+			t.Errorf("Expected a trade event, but got %v", event.Report)
 		}
 
-		if me.sellStopOrders.Len() != 0 {
-			t.Errorf("Expected 0 stop-loss orders, got %d", me.sellStopOrders.Len())
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 0 {
+			t.Errorf("Expected 0 stop-loss orders, got %d", me.instruments[DefaultSymbol].sellStopOrders.Len())
 		}
 	})
 }
 
 func TestMatchingEngine_PlaceOrders(t *testing.T) {
-	outputBuffer := NewRingBuffer(1024)
-	me := NewMatchingEngine(outputBuffer)
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngine(outputBuffer.buffer)
 
 	t.Run("should place multiple orders", func(t *testing.T) {
 		orders := []*Order{
@@ -182,3 +185,851 @@ func TestMatchingEngine_PlaceOrders(t *testing.T) {
 		}
 	})
 }
+
+func TestMatchingEngine_CancelOrder(t *testing.T) {
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngine(outputBuffer.buffer)
+
+	t.Run("should cancel a resting limit order", func(t *testing.T) {
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+		me.CancelOrder(&CancelRequest{OrderID: 1, OrdererID: 7})
+
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected an event, but got none")
+		}
+		cancelled, ok := event.Report.(OrderCancelled)
+		if !ok {
+			t.Fatalf("Expected a cancelled event, but got %v", event.Report)
+		}
+		if cancelled.OrderID != 1 || cancelled.Quantity != 10 {
+			t.Errorf("Expected order 1 with quantity 10 to be cancelled, got %+v", cancelled)
+		}
+		if me.GetOrderBook().BestBid() != nil {
+			t.Errorf("Expected order book to be empty, but got %v", me.GetOrderBook().BestBid())
+		}
+	})
+
+	t.Run("should cancel a pending stop-loss order", func(t *testing.T) {
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 7, Type: "stop-loss", Side: "sell", Price: 98 * PricePrecision, Quantity: 5})
+		me.CancelOrder(&CancelRequest{OrderID: 2, OrdererID: 7})
+
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected an event, but got none")
+		}
+		if _, ok := event.Report.(OrderCancelled); !ok {
+			t.Errorf("Expected a cancelled event, but got %v", event.Report)
+		}
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 0 {
+			t.Errorf("Expected 0 stop-loss orders, got %d", me.instruments[DefaultSymbol].sellStopOrders.Len())
+		}
+	})
+
+	t.Run("should reject a cancel from another orderer", func(t *testing.T) {
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 10})
+		me.CancelOrder(&CancelRequest{OrderID: 3, OrdererID: 8})
+
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected an event, but got none")
+		}
+		rejected, ok := event.Report.(CancelRejected)
+		if !ok || rejected.Reason != RejectNotOwner {
+			t.Errorf("Expected a not-owner rejection, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestAsk() == nil {
+			t.Error("Expected order to remain on the book")
+		}
+	})
+
+	t.Run("should reject a cancel for an unknown order", func(t *testing.T) {
+		me.CancelOrder(&CancelRequest{OrderID: 42, OrdererID: 7})
+
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected an event, but got none")
+		}
+		rejected, ok := event.Report.(CancelRejected)
+		if !ok || rejected.Reason != RejectUnknownOrder {
+			t.Errorf("Expected an unknown-order rejection, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should route cancels through the input buffer", func(t *testing.T) {
+		me.CancelOrders([]*CancelRequest{{OrderID: 3, OrdererID: 7}})
+
+		if me.inputBuffer.Size() != 1 {
+			t.Errorf("Expected 1 command in the input buffer, got %d", me.inputBuffer.Size())
+		}
+	})
+}
+
+func TestMatchingEngine_AmendOrder(t *testing.T) {
+	t.Run("should keep priority on a quantity decrease", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+
+		me.AmendOrder(&AmendRequest{OrderID: 1, OrdererID: 7, Quantity: 4})
+
+		event, _ := outputBuffer.Pop()
+		replaced, ok := event.Report.(OrderReplaced)
+		if !ok || !replaced.PriorityKept || replaced.Quantity != 4 {
+			t.Fatalf("Expected an in-place replace to quantity 4, but got %v", event.Report)
+		}
+		if best := me.GetOrderBook().BestAsk(); best.ID != 1 || best.Quantity != 4 {
+			t.Errorf("Expected order 1 with quantity 4 at the front, got %+v", best)
+		}
+	})
+
+	t.Run("should lose priority on a quantity increase", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+
+		me.AmendOrder(&AmendRequest{OrderID: 1, OrdererID: 7, Quantity: 15})
+
+		event, _ := outputBuffer.Pop()
+		if replaced, ok := event.Report.(OrderReplaced); !ok || replaced.PriorityKept {
+			t.Fatalf("Expected a replace that loses priority, but got %v", event.Report)
+		}
+		if best := me.GetOrderBook().BestAsk(); best.ID != 2 {
+			t.Errorf("Expected order 2 at the front, got %+v", best)
+		}
+	})
+
+	t.Run("should trade when a repriced order crosses the spread", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 4})
+
+		me.AmendOrder(&AmendRequest{OrderID: 2, OrdererID: 8, Price: 101 * PricePrecision})
+
+		event, _ := outputBuffer.Pop()
+		if _, ok := event.Report.(OrderReplaced); !ok {
+			t.Fatalf("Expected a replaced event, but got %v", event.Report)
+		}
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected a trade event, but got none")
+		}
+		if trade, ok := event.Report.(Trade); !ok || trade.TakerOrderID != 2 || trade.Quantity != 4 {
+			t.Errorf("Expected order 2 to take 4, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestBid() != nil {
+			t.Errorf("Expected no bids, but got %v", me.GetOrderBook().BestBid())
+		}
+		if me.GetOrderBook().BestAsk().Quantity != 6 {
+			t.Errorf("Expected best ask quantity to be 6, got %d", me.GetOrderBook().BestAsk().Quantity)
+		}
+	})
+
+	t.Run("should reject a post-only amend that would cross", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Type: "post-only", Side: "buy", Price: 99 * PricePrecision, Quantity: 4})
+
+		me.AmendOrder(&AmendRequest{OrderID: 2, OrdererID: 8, Price: 101 * PricePrecision})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(AmendRejected); !ok || rejected.Reason != RejectPostOnlyWouldCross {
+			t.Fatalf("Expected a post-only rejection, but got %v", event.Report)
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no trades, got %d more events", outputBuffer.Size())
+		}
+		if best := me.GetOrderBook().BestBid(); best.ID != 2 || best.Price != 99*PricePrecision {
+			t.Errorf("Expected order 2 to rest unchanged at %d, got %+v", 99*PricePrecision, best)
+		}
+	})
+
+	t.Run("should slide a post-only amend that would cross", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Type: "post-only", PostOnlyMode: "slide", Side: "buy", Price: 99 * PricePrecision, Quantity: 4})
+
+		me.AmendOrder(&AmendRequest{OrderID: 2, OrdererID: 8, Price: 101 * PricePrecision})
+
+		event, _ := outputBuffer.Pop()
+		if adjusted, ok := event.Report.(OrderPriceAdjusted); !ok || adjusted.Price != 101*PricePrecision-1 {
+			t.Fatalf("Expected an adjustment to %d, but got %v", 101*PricePrecision-1, event.Report)
+		}
+		event, _ = outputBuffer.Pop()
+		if replaced, ok := event.Report.(OrderReplaced); !ok || replaced.Price != 101*PricePrecision-1 {
+			t.Fatalf("Expected a replace at %d, but got %v", 101*PricePrecision-1, event.Report)
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no trades, got %d more events", outputBuffer.Size())
+		}
+		if best := me.GetOrderBook().BestBid(); best.ID != 2 || !best.PostOnly || best.Price != 101*PricePrecision-1 {
+			t.Errorf("Expected post-only order 2 to rest at %d, got %+v", 101*PricePrecision-1, best)
+		}
+		if me.GetOrderBook().BestAsk().Quantity != 10 {
+			t.Errorf("Expected best ask to be untouched, got %v", me.GetOrderBook().BestAsk())
+		}
+	})
+
+	t.Run("should reject an amend from another orderer", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+
+		me.AmendOrder(&AmendRequest{OrderID: 1, OrdererID: 8, Quantity: 1})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(AmendRejected); !ok || rejected.Reason != RejectNotOwner {
+			t.Errorf("Expected a not-owner rejection, but got %v", event.Report)
+		}
+	})
+}
+
+func TestMatchingEngine_PlacePostOnlyOrder(t *testing.T) {
+	t.Run("should rest a post-only order that does not cross", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 10})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "post-only", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no events, got %d", outputBuffer.Size())
+		}
+		if me.GetOrderBook().BestBid().ID != 2 {
+			t.Errorf("Expected order 2 to rest as best bid, got %v", me.GetOrderBook().BestBid())
+		}
+	})
+
+	t.Run("should reject a crossing post-only order", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 10})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "post-only", Side: "buy", Price: 101 * PricePrecision, Quantity: 10})
+
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected an event, but got none")
+		}
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectPostOnlyWouldCross {
+			t.Errorf("Expected a post-only rejection, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestBid() != nil {
+			t.Errorf("Expected no bids, but got %v", me.GetOrderBook().BestBid())
+		}
+		if me.GetOrderBook().BestAsk().Quantity != 10 {
+			t.Errorf("Expected best ask to be untouched, got %v", me.GetOrderBook().BestAsk())
+		}
+	})
+
+	t.Run("should slide a crossing post-only order to the best non-crossing tick", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "post-only", PostOnlyMode: "slide", Side: "sell", Price: 99 * PricePrecision, Quantity: 10})
+
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected an event, but got none")
+		}
+		adjusted, ok := event.Report.(OrderPriceAdjusted)
+		if !ok || adjusted.Price != 100*PricePrecision+1 {
+			t.Errorf("Expected an adjustment to %d, but got %v", 100*PricePrecision+1, event.Report)
+		}
+		if me.GetOrderBook().BestAsk().Price != 100*PricePrecision+1 {
+			t.Errorf("Expected best ask to be %d, got %d", 100*PricePrecision+1, me.GetOrderBook().BestAsk().Price)
+		}
+		if me.GetOrderBook().BestBid().Quantity != 10 {
+			t.Errorf("Expected best bid to be untouched, got %v", me.GetOrderBook().BestBid())
+		}
+	})
+}
+
+func TestMatchingEngine_PlaceIOCOrder(t *testing.T) {
+	outputBuffer := newTestOutput()
+	me := NewMatchingEngine(outputBuffer.buffer)
+
+	t.Run("should fill what it can and cancel the rest", func(t *testing.T) {
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 4})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "ioc", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.Quantity != 4 {
+			t.Fatalf("Expected a trade of 4, but got %v", event.Report)
+		}
+		event, _ = outputBuffer.Pop()
+		cancelled, ok := event.Report.(OrderCancelled)
+		if !ok || cancelled.Quantity != 6 || cancelled.Reason != CancelReasonIOCUnfilled {
+			t.Errorf("Expected the remaining 6 to be cancelled, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestBid() != nil {
+			t.Errorf("Expected IOC remainder not to rest, but got %v", me.GetOrderBook().BestBid())
+		}
+	})
+}
+
+func TestMatchingEngine_PlaceFOKOrder(t *testing.T) {
+	t.Run("should kill an order that cannot fill completely", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 4})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 102 * PricePrecision, Quantity: 10})
+
+		me.PlaceOrder(&Order{ID: 3, Type: "fok", Side: "buy", Price: 101 * PricePrecision, Quantity: 5})
+
+		event, _ := outputBuffer.Pop()
+		cancelled, ok := event.Report.(OrderCancelled)
+		if !ok || cancelled.Quantity != 5 || cancelled.Reason != CancelReasonFOKUnfilled {
+			t.Fatalf("Expected the whole order to be killed, but got %v", event.Report)
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no trades, got %d more events", outputBuffer.Size())
+		}
+		if me.GetOrderBook().BestAsk().Quantity != 4 {
+			t.Errorf("Expected the book to be untouched, got %v", me.GetOrderBook().BestAsk())
+		}
+	})
+
+	t.Run("should fill an order completely across levels", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 4})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 4})
+
+		me.PlaceOrder(&Order{ID: 3, Type: "fok", Side: "sell", Price: 99 * PricePrecision, Quantity: 6})
+
+		if outputBuffer.Size() != 2 {
+			t.Fatalf("Expected 2 trades, got %d events", outputBuffer.Size())
+		}
+		for i := 0; i < 2; i++ {
+			event, _ := outputBuffer.Pop()
+			if _, ok := event.Report.(Trade); !ok {
+				t.Errorf("Expected a trade event, but got %v", event.Report)
+			}
+		}
+		if me.GetOrderBook().BestBid().Quantity != 2 {
+			t.Errorf("Expected best bid quantity to be 2, got %d", me.GetOrderBook().BestBid().Quantity)
+		}
+	})
+}
+
+func TestMatchingEngine_PlaceAONOrder(t *testing.T) {
+	t.Run("should skip a resting AON order that the taker cannot fill", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "aon", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 3})
+
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 2 || trade.Quantity != 3 {
+			t.Fatalf("Expected a trade of 3 against order 2, but got %v", event.Report)
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no more trades, got %d events", outputBuffer.Size())
+		}
+		if best := me.GetOrderBook().BestAsk(); best.ID != 1 || best.Quantity != 10 {
+			t.Errorf("Expected the AON order to stay untouched, got %+v", best)
+		}
+		if me.GetOrderBook().BestBid().Quantity != 2 {
+			t.Errorf("Expected the remaining 2 to rest, got %v", me.GetOrderBook().BestBid())
+		}
+	})
+
+	t.Run("should fill a resting AON order completely", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "aon", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "market", Side: "buy", Quantity: 12})
+
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 1 || trade.Quantity != 10 {
+			t.Fatalf("Expected a trade of 10 against order 1, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestAsk() != nil {
+			t.Errorf("Expected order book to be empty, but got %v", me.GetOrderBook().BestAsk())
+		}
+	})
+
+	t.Run("should rest an AON taker that cannot be filled completely", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 4})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "aon", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		if outputBuffer.Size() != 0 {
+			t.Fatalf("Expected no trades, got %d events", outputBuffer.Size())
+		}
+		if best := me.GetOrderBook().BestBid(); best == nil || best.ID != 2 || !best.AllOrNone {
+			t.Fatalf("Expected the AON order to rest, got %+v", best)
+		}
+
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 6})
+		if outputBuffer.Size() != 0 {
+			t.Fatalf("Expected no trades against the resting AON order, got %d events", outputBuffer.Size())
+		}
+
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 2 || trade.Quantity != 10 {
+			t.Errorf("Expected order 4 to fill the AON order completely, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should execute an AON taker completely when liquidity allows", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 4})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 6})
+
+		me.PlaceOrder(&Order{ID: 3, Type: "aon", Side: "sell", Price: 99 * PricePrecision, Quantity: 10})
+
+		if outputBuffer.Size() != 2 {
+			t.Fatalf("Expected 2 trades, got %d events", outputBuffer.Size())
+		}
+		if me.GetOrderBook().BestBid() != nil || me.GetOrderBook().BestAsk() != nil {
+			t.Error("Expected order book to be empty")
+		}
+	})
+}
+
+func TestMatchingEngine_PlaceStopLimitOrder(t *testing.T) {
+	t.Run("should only trigger when the price falls to the stop", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-limit", Side: "sell", TriggerPrice: 98 * PricePrecision, Price: 97 * PricePrecision, Quantity: 5})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 99 * PricePrecision, Quantity: 1})
+		outputBuffer.Pop()
+
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 1 {
+			t.Fatalf("Expected the stop not to trigger above its price, got %d pending", me.instruments[DefaultSymbol].sellStopOrders.Len())
+		}
+
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "buy", Price: 98 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 5, Type: "limit", Side: "sell", Price: 98 * PricePrecision, Quantity: 1})
+		outputBuffer.Pop()
+
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 0 {
+			t.Errorf("Expected the stop to trigger, got %d pending", me.instruments[DefaultSymbol].sellStopOrders.Len())
+		}
+	})
+
+	t.Run("should rest at the limit price when it does not fill", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-limit", Side: "sell", TriggerPrice: 98 * PricePrecision, Price: 97 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 98 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "buy", Price: 96 * PricePrecision, Quantity: 5})
+
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "sell", Price: 98 * PricePrecision, Quantity: 1})
+
+		if outputBuffer.Size() != 1 {
+			t.Fatalf("Expected only the triggering trade, got %d events", outputBuffer.Size())
+		}
+		best := me.GetOrderBook().BestAsk()
+		if best == nil || best.ID != 1 || best.Price != 97*PricePrecision || best.Quantity != 5 {
+			t.Errorf("Expected the stop-limit to rest at %d, got %+v", 97*PricePrecision, best)
+		}
+	})
+
+	t.Run("should trade up to the limit price when triggered", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-limit", Side: "buy", TriggerPrice: 101 * PricePrecision, Price: 102 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 102 * PricePrecision, Quantity: 3})
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "sell", Price: 110 * PricePrecision, Quantity: 10})
+
+		me.PlaceOrder(&Order{ID: 5, Type: "limit", Side: "buy", Price: 101 * PricePrecision, Quantity: 1})
+		outputBuffer.Pop()
+
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected a trade from the triggered stop, but got none")
+		}
+		if trade, ok := event.Report.(Trade); !ok || trade.TakerOrderID != 1 || trade.Quantity != 3 {
+			t.Errorf("Expected the stop to take 3 at the limit, but got %v", event.Report)
+		}
+		if best := me.GetOrderBook().BestBid(); best == nil || best.ID != 1 || best.Quantity != 2 {
+			t.Errorf("Expected the remaining 2 to rest, got %+v", best)
+		}
+	})
+
+	t.Run("should reject a stop-limit without a trigger price", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-limit", Side: "sell", Price: 97 * PricePrecision, Quantity: 5})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectInvalidTrigger {
+			t.Errorf("Expected an invalid trigger rejection, but got %v", event.Report)
+		}
+	})
+}
+
+func TestMatchingEngine_PlaceIcebergOrder(t *testing.T) {
+	t.Run("should only display the peak", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 100, DisplayQuantity: 10})
+
+		if me.GetOrderBook().BestAsk().Quantity != 10 {
+			t.Errorf("Expected displayed quantity to be 10, got %d", me.GetOrderBook().BestAsk().Quantity)
+		}
+
+		me.TakeSnapshot()
+		event, _ := outputBuffer.Pop()
+		snapshot, ok := event.Report.(BookSnapshot)
+		if !ok {
+			t.Fatalf("Expected a book snapshot, but got %v", event.Report)
+		}
+		expected := fmt.Sprint([]SnapshotOrder{{OrderID: 1, Price: 100 * PricePrecision, Quantity: 10}})
+		if len(snapshot.Bids) != 0 || fmt.Sprint(snapshot.Asks) != expected {
+			t.Errorf("Expected the snapshot not to leak the hidden size, got %+v", snapshot)
+		}
+	})
+
+	t.Run("should replenish to the back of the queue", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 25, DisplayQuantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 1 || trade.Quantity != 10 {
+			t.Fatalf("Expected a trade of 10 against the iceberg, but got %v", event.Report)
+		}
+		if best := me.GetOrderBook().BestAsk(); best.ID != 2 {
+			t.Errorf("Expected order 2 to be ahead of the replenished iceberg, got %+v", best)
+		}
+		if iceberg := me.GetOrderBook().GetOrder(1); iceberg == nil || iceberg.Quantity != 10 {
+			t.Errorf("Expected the iceberg to show a new peak of 10, got %+v", iceberg)
+		}
+	})
+
+	t.Run("should keep trading against replenished peaks", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 25, DisplayQuantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+
+		me.PlaceOrder(&Order{ID: 3, Type: "market", Side: "buy", Quantity: 28})
+
+		expected := []struct{ maker, quantity int }{{1, 10}, {2, 5}, {1, 10}, {1, 3}}
+		for _, e := range expected {
+			event, _ := outputBuffer.Pop()
+			trade, ok := event.Report.(Trade)
+			if !ok || trade.MakerOrderID != e.maker || trade.Quantity != e.quantity {
+				t.Fatalf("Expected a trade of %d against order %d, but got %v", e.quantity, e.maker, event.Report)
+			}
+		}
+		if iceberg := me.GetOrderBook().GetOrder(1); iceberg == nil || iceberg.Quantity != 2 || iceberg.openQuantity() != 2 {
+			t.Errorf("Expected 2 left on the iceberg, got %+v", iceberg)
+		}
+	})
+
+	t.Run("should fill an FOK taker from the hidden reserve", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10, DisplayQuantity: 2})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "fok", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		traded := 0
+		for outputBuffer.Size() > 0 {
+			event, _ := outputBuffer.Pop()
+			trade, ok := event.Report.(Trade)
+			if !ok {
+				t.Fatalf("Expected only trades, but got %v", event.Report)
+			}
+			traded += trade.Quantity
+		}
+		if traded != 5 {
+			t.Errorf("Expected the FOK order to fill 5, got %d", traded)
+		}
+		if iceberg := me.GetOrderBook().GetOrder(1); iceberg == nil || iceberg.openQuantity() != 5 {
+			t.Errorf("Expected 5 of the iceberg to be left, got %+v", iceberg)
+		}
+	})
+
+	t.Run("should kill an FOK taker the reserve cannot cover", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10, DisplayQuantity: 2})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "fok", Side: "buy", Price: 100 * PricePrecision, Quantity: 11})
+
+		event, _ := outputBuffer.Pop()
+		if cancelled, ok := event.Report.(OrderCancelled); !ok || cancelled.Quantity != 11 || cancelled.Reason != CancelReasonFOKUnfilled {
+			t.Fatalf("Expected the FOK order to be killed, but got %v", event.Report)
+		}
+		if iceberg := me.GetOrderBook().GetOrder(1); iceberg == nil || iceberg.Quantity != 2 || iceberg.openQuantity() != 10 {
+			t.Errorf("Expected the iceberg to be untouched, got %+v", iceberg)
+		}
+	})
+
+	t.Run("should fill an AON taker from the hidden reserve", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10, DisplayQuantity: 2})
+
+		me.PlaceOrder(&Order{ID: 2, Type: "aon", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		if outputBuffer.Size() != 3 {
+			t.Fatalf("Expected 3 trades, got %d events", outputBuffer.Size())
+		}
+		if best := me.GetOrderBook().BestBid(); best != nil {
+			t.Errorf("Expected the AON order to fill completely, got %+v", best)
+		}
+		if iceberg := me.GetOrderBook().GetOrder(1); iceberg == nil || iceberg.openQuantity() != 5 {
+			t.Errorf("Expected 5 of the iceberg to be left, got %+v", iceberg)
+		}
+	})
+
+	t.Run("should cancel the hidden reserve too", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 25, DisplayQuantity: 10})
+
+		me.CancelOrder(&CancelRequest{OrderID: 1, OrdererID: 7})
+
+		event, _ := outputBuffer.Pop()
+		if cancelled, ok := event.Report.(OrderCancelled); !ok || cancelled.Quantity != 25 {
+			t.Errorf("Expected 25 to be cancelled, but got %v", event.Report)
+		}
+	})
+}
+
+func TestMatchingEngine_MultipleInstruments(t *testing.T) {
+	newEngine := func() (*MatchingEngine, *testOutput) {
+		outputBuffer := newTestOutput()
+		me := NewMultiInstrumentEngine(outputBuffer.buffer, []*OrderBookConfig{
+			{Symbol: "BTC-USD", MinTickSize: 1},
+			{Symbol: "ETH-USD", MinTickSize: 100},
+		})
+		return me, outputBuffer
+	}
+
+	t.Run("should keep separate books per instrument", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, Symbol: "BTC-USD", Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Symbol: "ETH-USD", Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		if outputBuffer.Size() != 0 {
+			t.Fatalf("Expected no trades across instruments, got %d events", outputBuffer.Size())
+		}
+		if me.GetInstrumentOrderBook("BTC-USD").BestAsk().ID != 1 || me.GetInstrumentOrderBook("ETH-USD").BestBid().ID != 2 {
+			t.Error("Expected each order to rest on its own book")
+		}
+	})
+
+	t.Run("should tag output events with the instrument", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, Symbol: "ETH-USD", Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Symbol: "ETH-USD", Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		event, _ := outputBuffer.Pop()
+		if _, ok := event.Report.(Trade); !ok || event.Symbol != "ETH-USD" {
+			t.Errorf("Expected an ETH-USD trade, but got %+v", event)
+		}
+	})
+
+	t.Run("should use each instrument's config", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, Symbol: "BTC-USD", Type: "limit", Side: "buy", Price: 12345, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Symbol: "ETH-USD", Type: "limit", Side: "buy", Price: 12345, Quantity: 5})
+
+		if price := me.GetInstrumentOrderBook("BTC-USD").BestBid().Price; price != 12345 {
+			t.Errorf("Expected BTC-USD price 12345, got %d", price)
+		}
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectInvalidTickSize || event.Symbol != "ETH-USD" {
+			t.Errorf("Expected the ETH-USD order to be off tick, but got %+v", event)
+		}
+	})
+
+	t.Run("should reject orders for unknown instruments", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, Symbol: "DOGE-USD", Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+		me.CancelOrder(&CancelRequest{Symbol: "DOGE-USD", OrderID: 1})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectUnknownInstrument || event.Symbol != "DOGE-USD" {
+			t.Errorf("Expected an unknown-instrument rejection, but got %+v", event)
+		}
+		event, _ = outputBuffer.Pop()
+		if rejected, ok := event.Report.(CancelRejected); !ok || rejected.Reason != RejectUnknownInstrument {
+			t.Errorf("Expected an unknown-instrument cancel rejection, but got %+v", event)
+		}
+		if me.GetInstrumentOrderBook("DOGE-USD") != nil {
+			t.Error("Expected no book to be created for an unknown instrument")
+		}
+	})
+
+	t.Run("should trigger stops only on their own instrument", func(t *testing.T) {
+		me, _ := newEngine()
+		me.PlaceOrder(&Order{ID: 1, Symbol: "BTC-USD", Type: "stop-loss", Side: "sell", Price: 98 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Symbol: "ETH-USD", Type: "limit", Side: "buy", Price: 98 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 3, Symbol: "ETH-USD", Type: "limit", Side: "sell", Price: 98 * PricePrecision, Quantity: 1})
+
+		if me.instruments["BTC-USD"].sellStopOrders.Len() != 1 {
+			t.Error("Expected the BTC-USD stop to ignore ETH-USD trades")
+		}
+	})
+
+	t.Run("should refuse to register an instrument twice", func(t *testing.T) {
+		me, _ := newEngine()
+		if err := me.AddInstrument(&OrderBookConfig{Symbol: "BTC-USD", MinTickSize: 1}); err == nil {
+			t.Error("Expected an error for a duplicate instrument")
+		}
+	})
+}
+
+func TestMatchingEngine_Sequences(t *testing.T) {
+	outputBuffer := NewRingBuffer(1024)
+	me := NewMatchingEngine(outputBuffer)
+	me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+	me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 2})
+	me.CancelOrder(&CancelRequest{OrderID: 9})
+	me.PlaceOrder(&Order{ID: 3, Symbol: "unknown", Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 2})
+
+	var events []Event
+	for {
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			break
+		}
+		events = append(events, event)
+	}
+
+	t.Run("should number output events without gaps", func(t *testing.T) {
+		for i, event := range events {
+			if event.OutputSequence != uint64(i+1) {
+				t.Errorf("Expected output sequence %d, got %d for %v", i+1, event.OutputSequence, event.Report)
+			}
+		}
+		if me.OutputSequence() != uint64(len(events)) {
+			t.Errorf("Expected the engine to report output sequence %d, got %d", len(events), me.OutputSequence())
+		}
+	})
+
+	t.Run("should tag output events with the input that caused them", func(t *testing.T) {
+		var sequences []uint64
+		for _, event := range events {
+			if len(sequences) == 0 || sequences[len(sequences)-1] != event.Sequence {
+				sequences = append(sequences, event.Sequence)
+			}
+		}
+		if fmt.Sprint(sequences) != "[1 2 3 4]" {
+			t.Errorf("Expected events for inputs [1 2 3 4], got %v", sequences)
+		}
+		if me.InputSequence() != 4 {
+			t.Errorf("Expected input sequence 4, got %d", me.InputSequence())
+		}
+	})
+
+	t.Run("should mark events published while replaying", func(t *testing.T) {
+		me.Replay(Event{Order: &Order{ID: 4, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 3}})
+		me.PlaceOrder(&Order{ID: 5, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 1})
+
+		var replayed, live int
+		for {
+			event, ok := outputBuffer.Pop()
+			if !ok {
+				break
+			}
+			if event.Replayed {
+				replayed++
+			} else {
+				live++
+			}
+		}
+		if replayed == 0 || live == 0 {
+			t.Errorf("Expected replayed and live events, got %d and %d", replayed, live)
+		}
+	})
+
+	t.Run("should leave a gap for events dropped by a full buffer", func(t *testing.T) {
+		fullBuffer := NewRingBuffer(2)
+		me := NewMatchingEngine(fullBuffer)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 102 * PricePrecision, Quantity: 5})
+
+		if fullBuffer.Size() != 1 {
+			t.Fatalf("Expected the buffer to hold 1 event, got %d", fullBuffer.Size())
+		}
+		fullBuffer.Pop()
+		me.PlaceOrder(&Order{ID: 4, Type: "limit", Side: "sell", Price: 103 * PricePrecision, Quantity: 5})
+
+		event, _ := fullBuffer.Pop()
+		if accepted, ok := event.Report.(OrderAccepted); !ok || accepted.OrderID != 4 || event.OutputSequence != 4 {
+			t.Errorf("Expected order 4's acceptance at output sequence 4, got %d %v", event.OutputSequence, event.Report)
+		}
+		if me.OutputSequence() != 4 {
+			t.Errorf("Expected the engine to count dropped events, got output sequence %d", me.OutputSequence())
+		}
+	})
+
+	t.Run("should count commands that publish nothing", func(t *testing.T) {
+		me.AdvanceClock(&ClockTick{Time: 1})
+		if me.InputSequence() != 7 {
+			t.Errorf("Expected input sequence 7, got %d", me.InputSequence())
+		}
+	})
+}
+
+// testOutput reads an engine's output buffer while skipping the accepted and
+// fill reports that accompany every order, so tests can assert on the events
+// they are about. Lifecycle reports are covered by TestMatchingEngine_ExecutionReports.
+type testOutput struct {
+	buffer  *RingBuffer
+	pending []Event
+}
+
+func newTestOutput() *testOutput {
+	return &testOutput{buffer: NewRingBuffer(1024)}
+}
+
+func (o *testOutput) drain() {
+	for {
+		event, ok := o.buffer.Pop()
+		if !ok {
+			return
+		}
+		switch event.Report.(type) {
+		case OrderAccepted, OrderPartiallyFilled, OrderFilled:
+			continue
+		}
+		o.pending = append(o.pending, event)
+	}
+}
+
+func (o *testOutput) Pop() (Event, bool) {
+	o.drain()
+	if len(o.pending) == 0 {
+		return Event{}, false
+	}
+	event := o.pending[0]
+	o.pending = o.pending[1:]
+	return event, true
+}
+
+func (o *testOutput) Size() uint64 {
+	o.drain()
+	return uint64(len(o.pending))
+}
@@ -0,0 +1,117 @@
+package matching
+
+import (
+	"fmt"
+)
+
+// Matching algorithms an instrument can be configured with.
+const (
+	MatchingFIFO            = "fifo"               // Price-time priority; the default.
+	MatchingProRata         = "pro-rata"           // Shared in proportion to size.
+	MatchingProRataTopOrder = "pro-rata-top-order" // The front order first, then pro-rata.
+)
+
+// A MatchingPolicy decides how an incoming order's quantity is shared among
+// the resting orders at one price level. Levels are always taken best price
+// first; the policy only decides what happens within a level.
+type MatchingPolicy interface {
+	// Allocate shares quantity among makers, which are given in time
+	// priority, by writing each maker's share to the matching index of
+	// allocations (zeroed, same length as makers). No share may exceed the
+	// maker's displayed Quantity, the shares may not add up to more than
+	// quantity, and an all-or-none maker gets its whole Quantity or nothing.
+	Allocate(quantity int, makers []*BookOrder, allocations []int)
+	// SharesLevel reports whether every order at a level can take part in
+	// the allocation. If not, the level is filled in time priority and
+	// planning stops as soon as the incoming order is used up.
+	SharesLevel() bool
+}
+
+// FIFO fills the orders at a level strictly in time priority.
+type FIFO struct{}
+
+func (FIFO) Allocate(quantity int, makers []*BookOrder, allocations []int) {
+	for i, maker := range makers {
+		if quantity == 0 {
+			return
+		}
+		if maker.AllOrNone && maker.Quantity > quantity {
+			continue
+		}
+		allocations[i] = min(maker.Quantity, quantity)
+		quantity -= allocations[i]
+	}
+}
+
+func (FIFO) SharesLevel() bool { return false }
+
+// ProRata shares a level in proportion to each order's displayed quantity,
+// rounded down. Shares smaller than MinimumAllocation are dropped, and what
+// rounding and dropping leave over goes to the orders in time priority. With
+// TopOrderPriority the order at the front of the level is filled first, and
+// only the rest is shared out.
+type ProRata struct {
+	MinimumAllocation int
+	TopOrderPriority  bool
+}
+
+func (p ProRata) Allocate(quantity int, makers []*BookOrder, allocations []int) {
+	if p.TopOrderPriority && len(makers) > 0 {
+		top := makers[0]
+		if !top.AllOrNone || top.Quantity <= quantity {
+			allocations[0] = min(top.Quantity, quantity)
+			quantity -= allocations[0]
+		}
+		makers, allocations = makers[1:], allocations[1:]
+	}
+
+	total := 0
+	for _, maker := range makers {
+		total += maker.Quantity
+	}
+	if total <= quantity {
+		for i, maker := range makers {
+			allocations[i] = maker.Quantity
+		}
+		return
+	}
+
+	left := quantity
+	for i, maker := range makers {
+		share := int(int64(quantity) * int64(maker.Quantity) / int64(total))
+		if share < p.MinimumAllocation || (maker.AllOrNone && share < maker.Quantity) {
+			share = 0
+		}
+		allocations[i] = share
+		left -= share
+	}
+
+	// Hand out the rounding remainder in time priority.
+	for i, maker := range makers {
+		if left == 0 {
+			return
+		}
+		room := maker.Quantity - allocations[i]
+		if maker.AllOrNone && room > left {
+			continue
+		}
+		extra := min(room, left)
+		allocations[i] += extra
+		left -= extra
+	}
+}
+
+func (ProRata) SharesLevel() bool { return true }
+
+// newMatchingPolicy returns the policy an instrument's config asks for.
+func newMatchingPolicy(config *OrderBookConfig) (MatchingPolicy, error) {
+	switch config.MatchingAlgorithm {
+	case "", MatchingFIFO:
+		return FIFO{}, nil
+	case MatchingProRata:
+		return ProRata{MinimumAllocation: config.ProRataMinimumAllocation}, nil
+	case MatchingProRataTopOrder:
+		return ProRata{MinimumAllocation: config.ProRataMinimumAllocation, TopOrderPriority: true}, nil
+	}
+	return nil, fmt.Errorf("instrument %q: unknown matching algorithm %q", config.Symbol, config.MatchingAlgorithm)
+}
@@ -0,0 +1,141 @@
+package matching
+
+import (
+	"fmt"
+	"testing"
+)
+
+func makers(quantities ...int) []*BookOrder {
+	orders := make([]*BookOrder, len(quantities))
+	for i, quantity := range quantities {
+		orders[i] = &BookOrder{ID: i + 1, Side: "sell", Price: 100 * PricePrecision, Quantity: quantity}
+	}
+	return orders
+}
+
+func allocate(policy MatchingPolicy, quantity int, orders []*BookOrder) []int {
+	allocations := make([]int, len(orders))
+	policy.Allocate(quantity, orders, allocations)
+	return allocations
+}
+
+func TestMatchingPolicy_Allocate(t *testing.T) {
+	aon := makers(10, 4, 10)
+	aon[1].AllOrNone = true
+
+	tests := []struct {
+		name     string
+		policy   MatchingPolicy
+		quantity int
+		makers   []*BookOrder
+		expected []int
+	}{
+		{"fifo fills in time priority", FIFO{}, 15, makers(10, 10, 10), []int{10, 5, 0}},
+		{"fifo skips an all-or-none order it cannot fill", FIFO{}, 12, aon, []int{10, 0, 2}},
+		{"pro-rata shares in proportion to size", ProRata{}, 50, makers(20, 30, 50), []int{10, 15, 25}},
+		{"pro-rata hands the rounding remainder out in time priority", ProRata{}, 10, makers(10, 10, 10), []int{4, 3, 3}},
+		{"pro-rata fills everything when the level is small enough", ProRata{}, 40, makers(10, 20), []int{10, 20}},
+		{"pro-rata drops shares below the minimum allocation", ProRata{MinimumAllocation: 2}, 20, makers(5, 90, 5), []int{2, 18, 0}},
+		{"pro-rata gives all-or-none orders everything or nothing", ProRata{}, 12, aon, []int{7, 0, 5}},
+		{"top order is filled before the rest is shared", ProRata{TopOrderPriority: true}, 30, makers(10, 20, 60), []int{10, 5, 15}},
+		{"top order takes everything when it is large enough", ProRata{TopOrderPriority: true}, 8, makers(10, 20), []int{8, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocations := allocate(tt.policy, tt.quantity, tt.makers)
+			if fmt.Sprint(allocations) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected allocations %v, got %v", tt.expected, allocations)
+			}
+		})
+	}
+}
+
+func TestMatchingEngine_ProRataMatching(t *testing.T) {
+	newEngine := func(algorithm string) (*MatchingEngine, *testOutput) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: 1, MatchingAlgorithm: algorithm})
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 2, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 30})
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 3, Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 10})
+		return me, outputBuffer
+	}
+
+	trades := func(outputBuffer *testOutput) []Trade {
+		var trades []Trade
+		for outputBuffer.Size() > 0 {
+			event, _ := outputBuffer.Pop()
+			if trade, ok := event.Report.(Trade); ok {
+				trades = append(trades, trade)
+			}
+		}
+		return trades
+	}
+
+	t.Run("should share the best level pro-rata", func(t *testing.T) {
+		me, outputBuffer := newEngine(MatchingProRata)
+		me.PlaceOrder(&Order{ID: 4, OrdererID: 4, Type: "market", Side: "buy", Quantity: 20})
+
+		expected := []Trade{
+			{TakerOrderID: 4, MakerOrderID: 1, Price: 100 * PricePrecision, Quantity: 5},
+			{TakerOrderID: 4, MakerOrderID: 2, Price: 100 * PricePrecision, Quantity: 15},
+		}
+		if result := trades(outputBuffer); fmt.Sprint(result) != fmt.Sprint(expected) {
+			t.Errorf("Expected trades %v, got %v", expected, result)
+		}
+	})
+
+	t.Run("should move to the next level once a level is used up", func(t *testing.T) {
+		me, outputBuffer := newEngine(MatchingProRataTopOrder)
+		me.PlaceOrder(&Order{ID: 4, OrdererID: 4, Type: "limit", Side: "buy", Price: 101 * PricePrecision, Quantity: 45})
+
+		expected := []Trade{
+			{TakerOrderID: 4, MakerOrderID: 1, Price: 100 * PricePrecision, Quantity: 10},
+			{TakerOrderID: 4, MakerOrderID: 2, Price: 100 * PricePrecision, Quantity: 30},
+			{TakerOrderID: 4, MakerOrderID: 3, Price: 101 * PricePrecision, Quantity: 5},
+		}
+		if result := trades(outputBuffer); fmt.Sprint(result) != fmt.Sprint(expected) {
+			t.Errorf("Expected trades %v, got %v", expected, result)
+		}
+	})
+
+	t.Run("should give the top order priority", func(t *testing.T) {
+		me, outputBuffer := newEngine(MatchingProRataTopOrder)
+		me.PlaceOrder(&Order{ID: 4, OrdererID: 4, Type: "market", Side: "buy", Quantity: 20})
+
+		expected := []Trade{
+			{TakerOrderID: 4, MakerOrderID: 1, Price: 100 * PricePrecision, Quantity: 10},
+			{TakerOrderID: 4, MakerOrderID: 2, Price: 100 * PricePrecision, Quantity: 10},
+		}
+		if result := trades(outputBuffer); fmt.Sprint(result) != fmt.Sprint(expected) {
+			t.Errorf("Expected trades %v, got %v", expected, result)
+		}
+	})
+
+	t.Run("should share a level around a self-trade", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: 1, MatchingAlgorithm: MatchingProRata, SelfTradePrevention: SelfTradeCancelOldest})
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 4, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 3, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 4, OrdererID: 4, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+
+		expected := []Trade{
+			{TakerOrderID: 4, MakerOrderID: 1, Price: 100 * PricePrecision, Quantity: 5},
+			{TakerOrderID: 4, MakerOrderID: 3, Price: 100 * PricePrecision, Quantity: 5},
+		}
+		if result := trades(outputBuffer); fmt.Sprint(result) != fmt.Sprint(expected) {
+			t.Errorf("Expected trades %v, got %v", expected, result)
+		}
+		if me.GetOrderBook().GetOrder(2) != nil {
+			t.Error("Expected the self-matched order 2 to be cancelled")
+		}
+	})
+
+	t.Run("should reject an unknown algorithm", func(t *testing.T) {
+		me := NewMatchingEngine(nil)
+		if err := me.AddInstrument(&OrderBookConfig{Symbol: "BTC-USD", MinTickSize: 1, MatchingAlgorithm: "lifo"}); err == nil {
+			t.Error("Expected an error for an unknown matching algorithm")
+		}
+	})
+}
@@ -1,133 +1,426 @@
 package matching
 
 import (
-	"container/heap"
+	"sort"
 )
 
 const PricePrecision = 10000
 
 type BookOrder struct {
-	ID       int
-	Side     string
-	Price    int64
-	Quantity int
+	ID        int
+	OrdererID int
+	Side      string
+	Price     int64
+	Quantity  int
+	AllOrNone bool // Only executes against a taker that can fill it completely.
+	PostOnly  bool // Only ever adds liquidity, also when it is amended.
+
+	// postOnlyMode and selfTradePrevention are the order's own settings,
+	// applied again when an amend re-enters it.
+	postOnlyMode        string
+	selfTradePrevention string
+	// expireAt is when a DAY or GTD order is due to expire, or 0.
+	expireAt int64
+
+	// Iceberg orders show at most peak at a time in Quantity and keep the
+	// rest in reserve. Both are unexported so the hidden size never leaves
+	// the engine.
+	peak    int
+	reserve int
+}
+
+// openQuantity is the displayed plus hidden quantity still to be filled.
+func (o *BookOrder) openQuantity() int {
+	return o.Quantity + o.reserve
+}
+
+// reduceTo shrinks an order's open quantity, taking it out of the hidden
+// reserve first so the displayed part keeps its place in the queue.
+func (o *BookOrder) reduceTo(quantity int) {
+	if quantity < o.Quantity {
+		o.Quantity = quantity
+	}
+	o.reserve = quantity - o.Quantity
+}
+
+// An orderNode links a resting order into the FIFO queue of its price level.
+type orderNode struct {
+	value      *BookOrder
+	level      *priceLevel
+	prev, next *orderNode
+}
+
+// A priceLevel holds every order resting at one price, oldest first, along
+// with the displayed quantity and number of orders at that price.
+type priceLevel struct {
+	price      int64
+	quantity   int
+	count      int
+	head, tail *orderNode
+}
+
+func (l *priceLevel) pushBack(node *orderNode) {
+	node.level = l
+	node.prev = l.tail
+	node.next = nil
+	if l.tail != nil {
+		l.tail.next = node
+	} else {
+		l.head = node
+	}
+	l.tail = node
+	l.quantity += node.value.Quantity
+	l.count++
+}
+
+func (l *priceLevel) unlink(node *orderNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		l.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		l.tail = node.prev
+	}
+	node.prev, node.next, node.level = nil, nil, nil
+	l.quantity -= node.value.Quantity
+	l.count--
 }
 
-// An Item is something we manage in a priority queue.
-type Item struct {
-	value    *BookOrder // The value of the item; arbitrary.
-	priority int64      // The priority of the item in the queue.
-	// The index is needed by update and is maintained by the heap.Interface methods.
-	index int // The index of the item in the heap.
+// A bookSide keeps the price levels of one side sorted from the worst price to
+// the best, so the best level is always at the end of the slice. A level that
+// empties away from the best price is left in place, so cancelling never
+// shifts the slice, and is reused if an order arrives at that price again.
+// Empty levels are compacted away once they make up more than half of the
+// slice, which keeps cancelling amortized O(1).
+type bookSide struct {
+	buy     bool
+	levels  []*priceLevel
+	byPrice map[int64]*priceLevel // Empty levels still in levels included.
+	empty   int                   // Number of empty levels in levels.
 }
 
-// A PriorityQueue implements heap.Interface and holds Items.
-type PriorityQueue []*Item
+func newBookSide(buy bool) *bookSide {
+	return &bookSide{
+		buy:     buy,
+		byPrice: make(map[int64]*priceLevel),
+	}
+}
 
-func (pq PriorityQueue) Len() int { return len(pq) }
+// better reports whether price a has priority over price b on this side.
+func (s *bookSide) better(a, b int64) bool {
+	if s.buy {
+		return a > b
+	}
+	return a < b
+}
 
-func (pq PriorityQueue) Less(i, j int) bool {
-	// We want Pop to give us the highest, not lowest, priority so we use greater than here.
-	if pq[i].priority == pq[j].priority {
-		// When priorities are equal, the older order gets priority
-		return pq[i].value.ID < pq[j].value.ID
+// search returns the index of the first level that is not worse than price.
+func (s *bookSide) search(price int64) int {
+	return sort.Search(len(s.levels), func(i int) bool {
+		return !s.better(price, s.levels[i].price)
+	})
+}
+
+// level returns the level an order at price joins, opening it if there is
+// none. Opening a level shifts the better levels up by one.
+func (s *bookSide) level(price int64) *priceLevel {
+	if level, ok := s.byPrice[price]; ok {
+		if level.count == 0 {
+			s.empty--
+		}
+		return level
 	}
-	return pq[i].priority > pq[j].priority
+	level := &priceLevel{price: price}
+	i := s.search(price)
+	s.levels = append(s.levels, nil)
+	copy(s.levels[i+1:], s.levels[i:])
+	s.levels[i] = level
+	s.byPrice[price] = level
+	return level
 }
 
-func (pq PriorityQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
-	pq[i].index = i
-	pq[j].index = j
+// levelEmptied is called once the last order has left a level. The best
+// level is dropped straight away, together with any empty levels behind it,
+// so the end of the slice is always the best price with orders on it.
+func (s *bookSide) levelEmptied(level *priceLevel) {
+	if s.levels[len(s.levels)-1] != level {
+		s.empty++
+		if s.empty > len(s.levels)/2 {
+			s.compact()
+		}
+		return
+	}
+	s.pop()
+	for len(s.levels) > 0 && s.levels[len(s.levels)-1].count == 0 {
+		s.pop()
+		s.empty--
+	}
 }
 
-func (pq *PriorityQueue) Push(x interface{}) {
-	n := len(*pq)
-	item := x.(*Item)
-	item.index = n
-	*pq = append(*pq, item)
+func (s *bookSide) pop() {
+	n := len(s.levels) - 1
+	delete(s.byPrice, s.levels[n].price)
+	s.levels[n] = nil
+	s.levels = s.levels[:n]
 }
 
-func (pq *PriorityQueue) Pop() interface{} {
-	old := *pq
-	n := len(old)
-	item := old[n-1]
-	old[n-1] = nil  // avoid memory leak
-	item.index = -1 // for safety
-	*pq = old[0 : n-1]
-	return item
+// compact drops every empty level.
+func (s *bookSide) compact() {
+	kept := s.levels[:0]
+	for _, level := range s.levels {
+		if level.count > 0 {
+			kept = append(kept, level)
+		} else {
+			delete(s.byPrice, level.price)
+		}
+	}
+	clear(s.levels[len(kept):])
+	s.levels = kept
+	s.empty = 0
+}
+
+func (s *bookSide) best() *priceLevel {
+	if len(s.levels) == 0 {
+		return nil
+	}
+	return s.levels[len(s.levels)-1]
 }
 
 type OrderBookConfig struct {
+	Symbol string
+	// MinTickSize is the price increment below the first TickLadder band,
+	// and everywhere if there is no ladder.
 	MinTickSize int64
+	TickLadder  []TickBand
+	// SelfTradePrevention is the STP mode used for orders that do not set
+	// their own. Empty allows self-trades.
+	SelfTradePrevention string
+	// Order limits checked before matching. Zero leaves a limit unset.
+	LotSize     int // Quantities must be a multiple of this.
+	MinQuantity int
+	MaxQuantity int
+	MinPrice    int64
+	MaxPrice    int64
+	// MinNotional is the smallest Price × Quantity an order may have, in the
+	// same fixed-point units as prices.
+	MinNotional int64
+	// Orders priced more than PriceBandBasisPoints away from the reference
+	// price are rejected, and matching stops at the edge of the band. The
+	// reference is the last trade, or with "vwap" the VWAP of the trades in
+	// the last VWAPWindow nanoseconds of engine time.
+	PriceBandBasisPoints int64
+	PriceBandReference   string
+	VWAPWindow           int64
+	// A move of more than VolatilityBasisPoints between trades less than
+	// VolatilityWindow nanoseconds apart halts trading for HaltDuration.
+	VolatilityBasisPoints int64
+	VolatilityWindow      int64
+	HaltDuration          int64
+	// OpeningAuction starts the instrument in a call auction, and a positive
+	// AuctionDuration reopens it through an auction of that length after a
+	// volatility halt.
+	OpeningAuction  bool
+	AuctionDuration int64
+	// MatchingAlgorithm is "fifo" (the default), "pro-rata" or
+	// "pro-rata-top-order". Pro-rata shares smaller than
+	// ProRataMinimumAllocation are not allocated.
+	MatchingAlgorithm        string
+	ProRataMinimumAllocation int
+	// FeeSchedules maps fee tiers to what their orderers pay. The "" tier
+	// is the default. Without schedules trading is free.
+	FeeSchedules map[string]FeeSchedule
 }
 
+// An OrderBook keeps resting orders in price levels, each a FIFO queue, so
+// adding at an existing price, cancelling (amortized) and reading the best
+// price are all O(1). Opening a new price level costs a binary search plus
+// shifting every better level up by one: cheap near the touch, O(levels) deep
+// in the book.
 type OrderBook struct {
-	orders map[int]*Item
-	bids   *PriorityQueue
-	asks   *PriorityQueue
+	orders map[int]*orderNode
+	bids   *bookSide
+	asks   *bookSide
 	config *OrderBookConfig
 }
 
 func NewOrderBook(config *OrderBookConfig) *OrderBook {
-	bids := &PriorityQueue{}
-	asks := &PriorityQueue{}
-	heap.Init(bids)
-	heap.Init(asks)
 	return &OrderBook{
-		orders: make(map[int]*Item),
-		bids:   bids,
-		asks:   asks,
+		orders: make(map[int]*orderNode),
+		bids:   newBookSide(true),
+		asks:   newBookSide(false),
 		config: config,
 	}
 }
 
+func (ob *OrderBook) side(side string) *bookSide {
+	if side == "buy" {
+		return ob.bids
+	}
+	return ob.asks
+}
+
 func (ob *OrderBook) AddOrder(order *BookOrder) {
 	order.Price = ob.roundPrice(order.Price)
-	item := &Item{
-		value:    order,
-		priority: order.Price,
-	}
-	ob.orders[order.ID] = item
-	if order.Side == "buy" {
-		heap.Push(ob.bids, item)
-	} else {
-		// For asks, we want the lowest price to have the highest priority.
-		item.priority = -order.Price
-		heap.Push(ob.asks, item)
-	}
+	node := &orderNode{value: order}
+	ob.orders[order.ID] = node
+	ob.side(order.Side).level(order.Price).pushBack(node)
 }
 
+// roundPrice rounds a price down to the tick grid of the band it falls in.
 func (ob *OrderBook) roundPrice(price int64) int64 {
-	return (price / ob.config.MinTickSize) * ob.config.MinTickSize
+	start, tick := ob.config.tickBand(price)
+	return start + (price-start)/tick*tick
 }
 
 func (ob *OrderBook) RemoveOrder(orderID int) {
-	item, ok := ob.orders[orderID]
+	node, ok := ob.orders[orderID]
 	if !ok {
 		return
 	}
 	delete(ob.orders, orderID)
 
-	var pq *PriorityQueue
-	if item.value.Side == "buy" {
-		pq = ob.bids
-	} else {
-		pq = ob.asks
+	level := node.level
+	level.unlink(node)
+	if level.count == 0 {
+		ob.side(node.value.Side).levelEmptied(level)
+	}
+}
+
+// Fill takes quantity off a resting order's displayed size. An order that is
+// used up leaves the book, unless it is an iceberg with reserve left, in which
+// case it is replenished. It reports whether that happened.
+func (ob *OrderBook) Fill(orderID int, quantity int) bool {
+	node, ok := ob.orders[orderID]
+	if !ok {
+		return false
+	}
+	order := node.value
+	order.Quantity -= quantity
+	node.level.quantity -= quantity
+	if order.Quantity > 0 {
+		return false
+	}
+	if order.reserve > 0 {
+		ob.Replenish(orderID)
+		return true
+	}
+	ob.RemoveOrder(orderID)
+	return false
+}
+
+// Reduce shrinks a resting order's open quantity in place, taking it out of
+// the hidden reserve first so the order keeps its place in the queue. Reducing
+// it to zero removes it.
+func (ob *OrderBook) Reduce(orderID int, quantity int) {
+	node, ok := ob.orders[orderID]
+	if !ok {
+		return
+	}
+	if quantity <= 0 {
+		ob.RemoveOrder(orderID)
+		return
+	}
+	order := node.value
+	displayed := order.Quantity
+	order.reduceTo(quantity)
+	node.level.quantity -= displayed - order.Quantity
+}
+
+// Replenish refills an iceberg order's displayed quantity from its reserve
+// and sends it to the back of the time queue at its price.
+func (ob *OrderBook) Replenish(orderID int) {
+	node, ok := ob.orders[orderID]
+	if !ok {
+		return
+	}
+	order := node.value
+	refill := order.peak
+	if order.reserve < refill {
+		refill = order.reserve
 	}
-	heap.Remove(pq, item.index)
+	ob.RemoveOrder(orderID)
+	order.Quantity += refill
+	order.reserve -= refill
+	ob.AddOrder(order)
+}
+
+// GetOrder returns the resting order with the given ID, or nil if there is none.
+func (ob *OrderBook) GetOrder(orderID int) *BookOrder {
+	node, ok := ob.orders[orderID]
+	if !ok {
+		return nil
+	}
+	return node.value
 }
 
 func (ob *OrderBook) BestBid() *BookOrder {
-	if ob.bids.Len() == 0 {
+	level := ob.bids.best()
+	if level == nil {
 		return nil
 	}
-	return (*ob.bids)[0].value
+	return level.head.value
 }
 
 func (ob *OrderBook) BestAsk() *BookOrder {
-	if ob.asks.Len() == 0 {
+	level := ob.asks.best()
+	if level == nil {
 		return nil
 	}
-	return (*ob.asks)[0].value
+	return level.head.value
+}
+
+// walk visits the orders on one side of the book in price-time priority until
+// fn returns false. The book is not modified, and visiting k orders costs
+// O(k) plus any empty levels passed over.
+func (ob *OrderBook) walk(side string, fn func(order *BookOrder) bool) {
+	levels := ob.side(side).levels
+	for i := len(levels) - 1; i >= 0; i-- {
+		for node := levels[i].head; node != nil; node = node.next {
+			if !fn(node.value) {
+				return
+			}
+		}
+	}
+}
+
+// A PriceLevel aggregates the orders resting at one price. Quantity is the
+// displayed quantity only; iceberg reserves are not shown.
+type PriceLevel struct {
+	Price    int64
+	Quantity int
+	Orders   int
+}
+
+// Depth is an L2 view of the book. Bids run from the highest price down and
+// asks from the lowest price up.
+type Depth struct {
+	Bids []PriceLevel
+	Asks []PriceLevel
+}
+
+// Depth returns the best n price levels on each side of the book, or every
+// level when n is zero or negative.
+func (ob *OrderBook) Depth(n int) Depth {
+	return Depth{
+		Bids: ob.bids.depth(n),
+		Asks: ob.asks.depth(n),
+	}
+}
+
+func (s *bookSide) depth(n int) []PriceLevel {
+	if live := len(s.levels) - s.empty; n <= 0 || n > live {
+		n = live
+	}
+	levels := make([]PriceLevel, 0, n)
+	for i := len(s.levels) - 1; i >= 0 && len(levels) < n; i-- {
+		level := s.levels[i]
+		if level.count == 0 {
+			continue
+		}
+		levels = append(levels, PriceLevel{Price: level.price, Quantity: level.quantity, Orders: level.count})
+	}
+	return levels
 }
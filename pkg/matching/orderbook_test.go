@@ -1,6 +1,7 @@
 package matching
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -78,3 +79,187 @@ func TestOrderBook_RoundPrice(t *testing.T) {
 		}
 	})
 }
+
+func TestOrderBook_Walk(t *testing.T) {
+	config := &OrderBookConfig{MinTickSize: 1}
+	ob := NewOrderBook(config)
+	ob.AddOrder(&BookOrder{ID: 1, Side: "sell", Price: 102 * PricePrecision, Quantity: 5})
+	ob.AddOrder(&BookOrder{ID: 2, Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+	ob.AddOrder(&BookOrder{ID: 3, Side: "sell", Price: 101 * PricePrecision, Quantity: 5})
+	ob.AddOrder(&BookOrder{ID: 4, Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+	ob.AddOrder(&BookOrder{ID: 5, Side: "sell", Price: 103 * PricePrecision, Quantity: 5})
+
+	t.Run("should visit orders in price-time priority", func(t *testing.T) {
+		var visited []int
+		ob.walk("sell", func(order *BookOrder) bool {
+			visited = append(visited, order.ID)
+			return true
+		})
+		expected := []int{2, 4, 3, 1, 5}
+		if len(visited) != len(expected) {
+			t.Fatalf("Expected to visit %v, got %v", expected, visited)
+		}
+		for i := range expected {
+			if visited[i] != expected[i] {
+				t.Fatalf("Expected to visit %v, got %v", expected, visited)
+			}
+		}
+	})
+
+	t.Run("should stop when asked to", func(t *testing.T) {
+		count := 0
+		ob.walk("sell", func(order *BookOrder) bool {
+			count++
+			return count < 2
+		})
+		if count != 2 {
+			t.Errorf("Expected to visit 2 orders, got %d", count)
+		}
+	})
+}
+
+func TestOrderBook_PriceLevels(t *testing.T) {
+	config := &OrderBookConfig{MinTickSize: 1}
+	ob := NewOrderBook(config)
+	ob.AddOrder(&BookOrder{ID: 1, Side: "buy", Price: 100 * PricePrecision, Quantity: 10})
+	ob.AddOrder(&BookOrder{ID: 2, Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+	ob.AddOrder(&BookOrder{ID: 3, Side: "buy", Price: 99 * PricePrecision, Quantity: 7})
+
+	t.Run("should aggregate orders at the same price", func(t *testing.T) {
+		if len(ob.bids.levels) != 2 {
+			t.Fatalf("Expected 2 bid levels, got %d", len(ob.bids.levels))
+		}
+		best := ob.bids.best()
+		if best.price != 100*PricePrecision || best.quantity != 15 || best.count != 2 {
+			t.Errorf("Expected best level 100 x 15 in 2 orders, got %d x %d in %d orders", best.price, best.quantity, best.count)
+		}
+	})
+
+	t.Run("should keep level quantity in step with fills and reductions", func(t *testing.T) {
+		ob.Fill(1, 4)
+		ob.Reduce(2, 3)
+		if best := ob.bids.best(); best.quantity != 9 {
+			t.Errorf("Expected best level quantity 9, got %d", best.quantity)
+		}
+	})
+
+	t.Run("should drop a level once its last order leaves", func(t *testing.T) {
+		ob.Fill(1, 6)
+		ob.RemoveOrder(2)
+		if best := ob.bids.best(); best.price != 99*PricePrecision {
+			t.Errorf("Expected best bid level 99, got %d", best.price)
+		}
+		if _, ok := ob.bids.byPrice[100*PricePrecision]; ok {
+			t.Errorf("Expected level 100 to be removed")
+		}
+	})
+}
+
+func TestOrderBook_EmptyLevels(t *testing.T) {
+	newBook := func() *OrderBook {
+		ob := NewOrderBook(&OrderBookConfig{MinTickSize: 1})
+		for i := 1; i <= 4; i++ {
+			ob.AddOrder(&BookOrder{ID: i, Side: "sell", Price: int64(100+i) * PricePrecision, Quantity: i})
+		}
+		return ob
+	}
+
+	t.Run("should leave an emptied level behind the best in place", func(t *testing.T) {
+		ob := newBook()
+		ob.RemoveOrder(3)
+		if len(ob.asks.levels) != 4 || ob.asks.empty != 1 {
+			t.Fatalf("Expected 4 levels with 1 empty, got %d with %d empty", len(ob.asks.levels), ob.asks.empty)
+		}
+		depth := ob.Depth(0)
+		expected := []PriceLevel{
+			{Price: 101 * PricePrecision, Quantity: 1, Orders: 1},
+			{Price: 102 * PricePrecision, Quantity: 2, Orders: 1},
+			{Price: 104 * PricePrecision, Quantity: 4, Orders: 1},
+		}
+		if fmt.Sprint(depth.Asks) != fmt.Sprint(expected) {
+			t.Errorf("Expected asks %v, got %v", expected, depth.Asks)
+		}
+		if depth := ob.Depth(3); len(depth.Asks) != 3 {
+			t.Errorf("Expected 3 ask levels, got %v", depth.Asks)
+		}
+
+		ob.AddOrder(&BookOrder{ID: 5, Side: "sell", Price: 103 * PricePrecision, Quantity: 5})
+		if len(ob.asks.levels) != 4 || ob.asks.empty != 0 {
+			t.Errorf("Expected the empty level to be reused, got %d levels with %d empty", len(ob.asks.levels), ob.asks.empty)
+		}
+	})
+
+	t.Run("should drop empty levels once the best level empties", func(t *testing.T) {
+		ob := newBook()
+		ob.RemoveOrder(2)
+		ob.RemoveOrder(1)
+		if best := ob.BestAsk(); best == nil || best.ID != 3 {
+			t.Fatalf("Expected order 3 to be the best ask, got %+v", best)
+		}
+		if len(ob.asks.levels) != 2 || ob.asks.empty != 0 || len(ob.asks.byPrice) != 2 {
+			t.Errorf("Expected 2 levels and no empty ones, got %d with %d empty", len(ob.asks.levels), ob.asks.empty)
+		}
+	})
+
+	t.Run("should compact once most levels are empty", func(t *testing.T) {
+		ob := newBook()
+		ob.RemoveOrder(2)
+		ob.RemoveOrder(3)
+		ob.RemoveOrder(4)
+		if len(ob.asks.levels) != 1 || ob.asks.empty != 0 || len(ob.asks.byPrice) != 1 {
+			t.Errorf("Expected only the best level to be left, got %d with %d empty", len(ob.asks.levels), ob.asks.empty)
+		}
+		count := 0
+		ob.walk("sell", func(order *BookOrder) bool {
+			count++
+			return true
+		})
+		if count != 1 {
+			t.Errorf("Expected to visit 1 order, got %d", count)
+		}
+	})
+}
+
+func TestOrderBook_Depth(t *testing.T) {
+	config := &OrderBookConfig{MinTickSize: 1}
+	ob := NewOrderBook(config)
+	ob.AddOrder(&BookOrder{ID: 1, Side: "buy", Price: 99 * PricePrecision, Quantity: 10})
+	ob.AddOrder(&BookOrder{ID: 2, Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+	ob.AddOrder(&BookOrder{ID: 3, Side: "buy", Price: 100 * PricePrecision, Quantity: 3})
+	ob.AddOrder(&BookOrder{ID: 4, Side: "buy", Price: 98 * PricePrecision, Quantity: 1})
+	ob.AddOrder(&BookOrder{ID: 5, Side: "sell", Price: 102 * PricePrecision, Quantity: 4})
+	ob.AddOrder(&BookOrder{ID: 6, Side: "sell", Price: 101 * PricePrecision, Quantity: 6})
+
+	t.Run("should return the top levels of each side in price order", func(t *testing.T) {
+		depth := ob.Depth(2)
+		expectedBids := []PriceLevel{
+			{Price: 100 * PricePrecision, Quantity: 8, Orders: 2},
+			{Price: 99 * PricePrecision, Quantity: 10, Orders: 1},
+		}
+		expectedAsks := []PriceLevel{
+			{Price: 101 * PricePrecision, Quantity: 6, Orders: 1},
+			{Price: 102 * PricePrecision, Quantity: 4, Orders: 1},
+		}
+		if fmt.Sprint(depth.Bids) != fmt.Sprint(expectedBids) {
+			t.Errorf("Expected bids %v, got %v", expectedBids, depth.Bids)
+		}
+		if fmt.Sprint(depth.Asks) != fmt.Sprint(expectedAsks) {
+			t.Errorf("Expected asks %v, got %v", expectedAsks, depth.Asks)
+		}
+	})
+
+	t.Run("should return every level when n is not positive", func(t *testing.T) {
+		depth := ob.Depth(0)
+		if len(depth.Bids) != 3 || len(depth.Asks) != 2 {
+			t.Errorf("Expected 3 bid and 2 ask levels, got %d and %d", len(depth.Bids), len(depth.Asks))
+		}
+	})
+
+	t.Run("should show only the displayed part of an iceberg", func(t *testing.T) {
+		ob.AddOrder(&BookOrder{ID: 7, Side: "sell", Price: 103 * PricePrecision, Quantity: 2, peak: 2, reserve: 8})
+		depth := ob.Depth(0)
+		if last := depth.Asks[len(depth.Asks)-1]; last.Quantity != 2 {
+			t.Errorf("Expected displayed quantity 2, got %d", last.Quantity)
+		}
+	})
+}
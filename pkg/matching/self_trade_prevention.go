@@ -0,0 +1,97 @@
+package matching
+
+// Self-trade prevention (STP) modes. They decide what happens when an incoming
+// order would match a resting order of the same OrdererID.
+const (
+	SelfTradeAllow              = ""
+	SelfTradeCancelNewest       = "cancel-newest"        // Cancel the rest of the incoming order.
+	SelfTradeCancelOldest       = "cancel-oldest"        // Cancel the resting order and keep matching.
+	SelfTradeCancelBoth         = "cancel-both"          // Cancel both orders.
+	SelfTradeDecrementAndCancel = "decrement-and-cancel" // Reduce both by the smaller size; cancel whichever reaches zero.
+
+	RejectInvalidSelfTradePrevention = "invalid-self-trade-prevention"
+)
+
+// validSelfTradePrevention reports whether mode is one of the STP modes.
+func validSelfTradePrevention(mode string) bool {
+	switch mode {
+	case SelfTradeAllow, SelfTradeCancelNewest, SelfTradeCancelOldest, SelfTradeCancelBoth, SelfTradeDecrementAndCancel:
+		return true
+	}
+	return false
+}
+
+// SelfTradePrevented is published instead of a Trade when an order meets one
+// of its owner's resting orders. The cancelled quantities say how much was
+// taken off each side.
+type SelfTradePrevented struct {
+	Mode                   string
+	OrdererID              int
+	TakerOrderID           int
+	MakerOrderID           int
+	TakerCancelledQuantity int
+	MakerCancelledQuantity int
+}
+
+func (in *instrument) selfTradePreventionMode(order *Order) string {
+	if order.SelfTradePrevention != "" {
+		return order.SelfTradePrevention
+	}
+	return in.orderBook.config.SelfTradePrevention
+}
+
+// remainingAfterSelfTrade tells planFills how much of the incoming order is
+// left to match after preventing a self-trade against maker.
+func remainingAfterSelfTrade(mode string, remaining int, maker *BookOrder) int {
+	switch mode {
+	case SelfTradeCancelOldest:
+		return remaining
+	case SelfTradeDecrementAndCancel:
+		if open := maker.openQuantity(); open < remaining {
+			return remaining - open
+		}
+		return 0
+	default:
+		// cancel-newest and cancel-both end the incoming order, and so does
+		// any mode that got past validation; see preventSelfTrade.
+		return 0
+	}
+}
+
+// preventSelfTrade resolves a planned self-match between the incoming order
+// and one of its owner's resting orders, and reports what was cancelled. A
+// cancelled taker has its quantity zeroed so nothing of it rests.
+func (in *instrument) preventSelfTrade(order *Order, maker *BookOrder) {
+	mode := in.selfTradePreventionMode(order)
+	prevented := SelfTradePrevented{
+		Mode:         mode,
+		OrdererID:    order.OrdererID,
+		TakerOrderID: order.ID,
+		MakerOrderID: maker.ID,
+	}
+
+	makerOpen := maker.openQuantity()
+	switch mode {
+	case SelfTradeCancelOldest:
+		prevented.MakerCancelledQuantity = makerOpen
+	case SelfTradeCancelBoth:
+		prevented.TakerCancelledQuantity = order.Quantity
+		prevented.MakerCancelledQuantity = makerOpen
+	case SelfTradeDecrementAndCancel:
+		decrement := order.Quantity
+		if makerOpen < decrement {
+			decrement = makerOpen
+		}
+		prevented.TakerCancelledQuantity = decrement
+		prevented.MakerCancelledQuantity = decrement
+	default:
+		// cancel-newest. Unknown modes are rejected on entry, but one that
+		// got through still cancels the taker rather than let it trade or
+		// rest through its owner's order.
+		prevented.TakerCancelledQuantity = order.Quantity
+	}
+
+	order.Quantity -= prevented.TakerCancelledQuantity
+	in.orderBook.Reduce(maker.ID, makerOpen-prevented.MakerCancelledQuantity)
+	in.publish(prevented)
+}
@@ -0,0 +1,197 @@
+package matching
+
+import (
+	"testing"
+)
+
+func TestMatchingEngine_SelfTradePrevention(t *testing.T) {
+	setup := func(mode string) (*MatchingEngine, *testOutput) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, &OrderBookConfig{MinTickSize: 1, SelfTradePrevention: mode})
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+		return me, outputBuffer
+	}
+
+	popPrevented := func(t *testing.T, outputBuffer *testOutput) SelfTradePrevented {
+		t.Helper()
+		event, ok := outputBuffer.Pop()
+		if !ok {
+			t.Fatal("Expected an event, but got none")
+		}
+		prevented, ok := event.Report.(SelfTradePrevented)
+		if !ok {
+			t.Fatalf("Expected an STP event, but got %v", event.Report)
+		}
+		return prevented
+	}
+
+	t.Run("should allow self-trades when STP is off", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeAllow)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 1 {
+			t.Errorf("Expected a trade against order 1, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should cancel the incoming order with cancel-newest", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeCancelNewest)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 8})
+
+		prevented := popPrevented(t, outputBuffer)
+		if prevented.TakerCancelledQuantity != 8 || prevented.MakerCancelledQuantity != 0 {
+			t.Errorf("Expected the whole taker to be cancelled, got %+v", prevented)
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no trades, got %d more events", outputBuffer.Size())
+		}
+		if me.GetOrderBook().BestBid() != nil || me.GetOrderBook().BestAsk().ID != 1 {
+			t.Error("Expected the resting order to stay and the taker not to rest")
+		}
+	})
+
+	t.Run("should cancel the resting order with cancel-oldest and keep matching", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeCancelOldest)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 8})
+
+		prevented := popPrevented(t, outputBuffer)
+		if prevented.MakerOrderID != 1 || prevented.MakerCancelledQuantity != 5 || prevented.TakerCancelledQuantity != 0 {
+			t.Errorf("Expected order 1 to be cancelled, got %+v", prevented)
+		}
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 2 || trade.Quantity != 5 {
+			t.Errorf("Expected a trade of 5 against order 2, but got %v", event.Report)
+		}
+		if best := me.GetOrderBook().BestBid(); best == nil || best.Quantity != 3 {
+			t.Errorf("Expected the remaining 3 to rest, got %+v", best)
+		}
+	})
+
+	t.Run("should cancel both orders with cancel-both", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeCancelBoth)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 8})
+
+		prevented := popPrevented(t, outputBuffer)
+		if prevented.MakerCancelledQuantity != 5 || prevented.TakerCancelledQuantity != 8 {
+			t.Errorf("Expected both orders to be cancelled, got %+v", prevented)
+		}
+		if me.GetOrderBook().BestBid() != nil || me.GetOrderBook().BestAsk().ID != 2 {
+			t.Error("Expected only order 2 to be left on the book")
+		}
+	})
+
+	t.Run("should decrement both and cancel the smaller with decrement-and-cancel", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeDecrementAndCancel)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 3})
+
+		prevented := popPrevented(t, outputBuffer)
+		if prevented.MakerCancelledQuantity != 3 || prevented.TakerCancelledQuantity != 3 {
+			t.Errorf("Expected both orders to be decremented by 3, got %+v", prevented)
+		}
+		if best := me.GetOrderBook().BestAsk(); best.ID != 1 || best.Quantity != 2 {
+			t.Errorf("Expected order 1 to keep 2 at the front, got %+v", best)
+		}
+		if me.GetOrderBook().BestBid() != nil {
+			t.Errorf("Expected the taker to be used up, got %v", me.GetOrderBook().BestBid())
+		}
+	})
+
+	t.Run("should kill an FOK that would meet its owner's order with cancel-newest", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeCancelNewest)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "fok", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		event, _ := outputBuffer.Pop()
+		if cancelled, ok := event.Report.(OrderCancelled); !ok || cancelled.Quantity != 5 || cancelled.Reason != CancelReasonFOKUnfilled {
+			t.Fatalf("Expected the FOK order to be killed, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestAsk().ID != 1 || me.GetOrderBook().GetOrder(2) == nil {
+			t.Error("Expected both resting orders to stay")
+		}
+	})
+
+	t.Run("should fill an FOK past its owner's order with cancel-oldest", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeCancelOldest)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "fok", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		if prevented := popPrevented(t, outputBuffer); prevented.MakerOrderID != 1 {
+			t.Errorf("Expected order 1 to be cancelled, got %+v", prevented)
+		}
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 2 || trade.Quantity != 5 {
+			t.Errorf("Expected a trade of 5 against order 2, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestAsk() != nil {
+			t.Errorf("Expected the book to be empty, got %+v", me.GetOrderBook().BestAsk())
+		}
+	})
+
+	t.Run("should let an order override the book's STP mode", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeAllow)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "market", Side: "buy", Quantity: 5, SelfTradePrevention: SelfTradeCancelOldest})
+
+		prevented := popPrevented(t, outputBuffer)
+		if prevented.Mode != SelfTradeCancelOldest {
+			t.Errorf("Expected cancel-oldest, got %+v", prevented)
+		}
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.MakerOrderID != 2 {
+			t.Errorf("Expected a trade against order 2, but got %v", event.Report)
+		}
+	})
+	t.Run("should reject an unknown STP mode", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeAllow)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "limit", Side: "buy", Price: 110 * PricePrecision, Quantity: 5, SelfTradePrevention: "bogus"})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectInvalidSelfTradePrevention {
+			t.Fatalf("Expected an STP mode rejection, but got %v", event.Report)
+		}
+		if me.GetOrderBook().BestBid() != nil {
+			t.Errorf("Expected nothing to rest, got %+v", me.GetOrderBook().BestBid())
+		}
+	})
+
+	t.Run("should keep an order's STP mode when an amend re-enters it", func(t *testing.T) {
+		me, outputBuffer := setup(SelfTradeAllow)
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 5, SelfTradePrevention: SelfTradeCancelNewest})
+
+		me.AmendOrder(&AmendRequest{OrderID: 3, OrdererID: 7, Price: 100 * PricePrecision})
+
+		event, _ := outputBuffer.Pop()
+		if _, ok := event.Report.(OrderReplaced); !ok {
+			t.Fatalf("Expected a replaced event, but got %v", event.Report)
+		}
+		if prevented := popPrevented(t, outputBuffer); prevented.TakerOrderID != 3 || prevented.MakerOrderID != 1 || prevented.TakerCancelledQuantity != 5 {
+			t.Errorf("Expected order 3 to be cancelled against order 1, got %+v", prevented)
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no trades, got %d more events", outputBuffer.Size())
+		}
+		if best := me.GetOrderBook().BestAsk(); best.ID != 1 || best.Quantity != 5 {
+			t.Errorf("Expected order 1 to be untouched, got %+v", best)
+		}
+	})
+
+	t.Run("should keep a stop order's STP mode when it triggers", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "buy", Price: 98 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 7, Type: "stop-loss", Side: "sell", TriggerPrice: 99 * PricePrecision, Quantity: 3, SelfTradePrevention: SelfTradeCancelNewest})
+
+		me.PlaceOrder(&Order{ID: 4, OrdererID: 9, Type: "limit", Side: "sell", Price: 99 * PricePrecision, Quantity: 1})
+
+		event, _ := outputBuffer.Pop()
+		if trade, ok := event.Report.(Trade); !ok || trade.TakerOrderID != 4 {
+			t.Fatalf("Expected the trade that triggers the stop, but got %v", event.Report)
+		}
+		if prevented := popPrevented(t, outputBuffer); prevented.TakerOrderID != 3 || prevented.MakerOrderID != 1 || prevented.TakerCancelledQuantity != 3 {
+			t.Errorf("Expected the triggered stop to be cancelled against order 1, got %+v", prevented)
+		}
+		if best := me.GetOrderBook().BestBid(); best.ID != 1 || best.Quantity != 5 {
+			t.Errorf("Expected order 1 to be untouched, got %+v", best)
+		}
+	})
+}
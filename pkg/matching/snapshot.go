@@ -0,0 +1,669 @@
+package matching
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+)
+
+// A snapshot is the complete engine state in a versioned binary format:
+//
+//	magic "MESN" | version (uvarint) | engine state | CRC-32 (4 bytes, big endian)
+//
+// Integers are varints and strings are length-prefixed. Book orders are
+// written best price first in time priority, and the stop and expiry queues
+// in heap order, so a restored engine matches exactly like the original and
+// snapshots itself to the same bytes.
+const (
+	snapshotMagic   = "MESN"
+	snapshotVersion = 1
+)
+
+var errSnapshotTruncated = errors.New("snapshot is truncated")
+
+// engineState is a copy of everything an engine needs to carry on where it
+// left off. Capturing it is cheap; encoding it can happen elsewhere.
+type engineState struct {
+	now            int64
+	inputSequence  uint64
+	outputSequence uint64
+	feeTiers       []feeTier
+	instruments    []instrumentState
+}
+
+type feeTier struct {
+	ordererID int
+	tier      string
+}
+
+type instrumentState struct {
+	config            OrderBookConfig
+	bids              []BookOrder // Best price first, in time priority.
+	asks              []BookOrder
+	buyStopOrders     []stopState // Heap order.
+	sellStopOrders    []stopState
+	buyTrailingStops  []trailingState
+	sellTrailingStops []trailingState
+	stopSequence      uint64
+	expiries          []expiry
+	expirySequence    uint64
+	lastTradePrice    int64
+	vwapWindow        *tradeWindow
+	volatilityWindow  *tradeWindow
+	phase             string
+	phaseEndsAt       int64
+	indicative        IndicativeAuctionPrice
+}
+
+type stopState struct {
+	order    Order
+	priority int64
+	sequence uint64
+}
+
+// trailingState refers to the stop order it tracks by ID.
+type trailingState struct {
+	orderID  int
+	priority int64
+	sequence uint64
+}
+
+// Snapshot returns the engine's state in the binary snapshot format. The
+// snapshot records the last processed input sequence, so replay can resume
+// right after it.
+func (me *MatchingEngine) Snapshot() []byte {
+	return me.captureState().encode()
+}
+
+// Restore rebuilds an engine from a snapshot taken by Snapshot. The restored
+// engine publishes to outputBuffer and continues both sequences where the
+// snapshot left off.
+func Restore(outputBuffer *RingBuffer, snapshot []byte) (*MatchingEngine, error) {
+	state, err := decodeState(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return state.restore(outputBuffer)
+}
+
+func (me *MatchingEngine) captureState() *engineState {
+	state := &engineState{
+		now:            me.now,
+		inputSequence:  me.inputSequence,
+		outputSequence: me.outputSequence,
+	}
+	for ordererID, tier := range me.feeTiers {
+		state.feeTiers = append(state.feeTiers, feeTier{ordererID: ordererID, tier: tier})
+	}
+	sort.Slice(state.feeTiers, func(i, j int) bool {
+		return state.feeTiers[i].ordererID < state.feeTiers[j].ordererID
+	})
+	for _, symbol := range me.symbols {
+		state.instruments = append(state.instruments, me.instruments[symbol].captureState())
+	}
+	return state
+}
+
+func (in *instrument) captureState() instrumentState {
+	return instrumentState{
+		config:            in.orderBook.config.clone(),
+		bids:              captureBookSide(in.orderBook, "buy"),
+		asks:              captureBookSide(in.orderBook, "sell"),
+		buyStopOrders:     captureStops(*in.buyStopOrders),
+		sellStopOrders:    captureStops(*in.sellStopOrders),
+		buyTrailingStops:  captureTrailingStops(*in.buyTrailingStops),
+		sellTrailingStops: captureTrailingStops(*in.sellTrailingStops),
+		stopSequence:      in.stopSequence,
+		expiries:          append([]expiry(nil), *in.expiries...),
+		expirySequence:    in.expirySequence,
+		lastTradePrice:    in.lastTradePrice,
+		vwapWindow:        in.vwapWindow.clone(),
+		volatilityWindow:  in.volatilityWindow.clone(),
+		phase:             in.phase,
+		phaseEndsAt:       in.phaseEndsAt,
+		indicative:        in.indicative,
+	}
+}
+
+func captureBookSide(ob *OrderBook, side string) []BookOrder {
+	var orders []BookOrder
+	ob.walk(side, func(order *BookOrder) bool {
+		orders = append(orders, *order)
+		return true
+	})
+	return orders
+}
+
+func captureStops(queue StopLossQueue) []stopState {
+	stops := make([]stopState, len(queue))
+	for i, item := range queue {
+		stops[i] = stopState{order: *item.value, priority: item.priority, sequence: item.sequence}
+	}
+	return stops
+}
+
+func captureTrailingStops(queue StopLossQueue) []trailingState {
+	stops := make([]trailingState, len(queue))
+	for i, item := range queue {
+		stops[i] = trailingState{orderID: item.value.ID, priority: item.priority, sequence: item.sequence}
+	}
+	return stops
+}
+
+// clone copies a config so a snapshot does not share its ladder or fee
+// schedules with the live engine.
+func (c *OrderBookConfig) clone() OrderBookConfig {
+	config := *c
+	config.TickLadder = append([]TickBand(nil), c.TickLadder...)
+	if c.FeeSchedules != nil {
+		config.FeeSchedules = make(map[string]FeeSchedule, len(c.FeeSchedules))
+		for tier, schedule := range c.FeeSchedules {
+			config.FeeSchedules[tier] = schedule
+		}
+	}
+	return config
+}
+
+func (w *tradeWindow) clone() *tradeWindow {
+	if w == nil {
+		return nil
+	}
+	return &tradeWindow{
+		length:   w.length,
+		trades:   append([]tradePoint(nil), w.trades...),
+		notional: w.notional,
+		quantity: w.quantity,
+		lows:     append([]tradePoint(nil), w.lows...),
+		highs:    append([]tradePoint(nil), w.highs...),
+	}
+}
+
+func (state *engineState) restore(outputBuffer *RingBuffer) (*MatchingEngine, error) {
+	me := NewMultiInstrumentEngine(outputBuffer, nil)
+	me.now = state.now
+	me.inputSequence = state.inputSequence
+	me.outputSequence = state.outputSequence
+	for _, tier := range state.feeTiers {
+		me.feeTiers[tier.ordererID] = tier.tier
+	}
+	for i := range state.instruments {
+		instrumentState := &state.instruments[i]
+		config := instrumentState.config.clone()
+		if err := me.AddInstrument(&config); err != nil {
+			return nil, fmt.Errorf("restoring snapshot: %w", err)
+		}
+		if err := me.instruments[config.Symbol].restore(instrumentState); err != nil {
+			return nil, fmt.Errorf("restoring snapshot: instrument %q: %w", config.Symbol, err)
+		}
+	}
+	return me, nil
+}
+
+func (in *instrument) restore(state *instrumentState) error {
+	for _, orders := range [][]BookOrder{state.bids, state.asks} {
+		for _, order := range orders {
+			if in.orderBook.GetOrder(order.ID) != nil {
+				return fmt.Errorf("duplicate book order %d", order.ID)
+			}
+			bookOrder := order
+			in.orderBook.AddOrder(&bookOrder)
+		}
+	}
+
+	for _, queue := range []struct {
+		heap  *StopLossQueue
+		stops []stopState
+	}{{in.buyStopOrders, state.buyStopOrders}, {in.sellStopOrders, state.sellStopOrders}} {
+		for i, stop := range queue.stops {
+			if _, ok := in.stopOrders[stop.order.ID]; ok {
+				return fmt.Errorf("duplicate stop order %d", stop.order.ID)
+			}
+			order := stop.order
+			item := &StopLossOrder{value: &order, priority: stop.priority, sequence: stop.sequence, index: i}
+			*queue.heap = append(*queue.heap, item)
+			in.stopOrders[order.ID] = item
+		}
+	}
+	for _, queue := range []struct {
+		heap  *StopLossQueue
+		stops []trailingState
+	}{{in.buyTrailingStops, state.buyTrailingStops}, {in.sellTrailingStops, state.sellTrailingStops}} {
+		for i, stop := range queue.stops {
+			stopOrder, ok := in.stopOrders[stop.orderID]
+			if !ok {
+				return fmt.Errorf("trailing stop %d has no stop order", stop.orderID)
+			}
+			item := &StopLossOrder{value: stopOrder.value, priority: stop.priority, sequence: stop.sequence, index: i}
+			*queue.heap = append(*queue.heap, item)
+			in.trailingStops[stop.orderID] = item
+		}
+	}
+	in.stopSequence = state.stopSequence
+
+	*in.expiries = append(expiryQueue(nil), state.expiries...)
+	in.expirySequence = state.expirySequence
+	in.lastTradePrice = state.lastTradePrice
+
+	if (state.vwapWindow != nil) != (in.vwapWindow != nil) || (state.volatilityWindow != nil) != (in.volatilityWindow != nil) {
+		return errors.New("trade windows do not match the config")
+	}
+	in.vwapWindow = state.vwapWindow.clone()
+	in.volatilityWindow = state.volatilityWindow.clone()
+
+	switch state.phase {
+	case PhaseContinuous, PhaseAuction, PhaseHalted:
+	default:
+		return fmt.Errorf("unknown trading phase %q", state.phase)
+	}
+	in.phase = state.phase
+	in.phaseEndsAt = state.phaseEndsAt
+	in.indicative = state.indicative
+	return nil
+}
+
+func (state *engineState) encode() []byte {
+	w := &snapshotWriter{buf: make([]byte, 0, 4096)}
+	w.buf = append(w.buf, snapshotMagic...)
+	w.writeUint(snapshotVersion)
+
+	w.writeInt(state.now)
+	w.writeUint(state.inputSequence)
+	w.writeUint(state.outputSequence)
+	w.writeUint(uint64(len(state.feeTiers)))
+	for _, tier := range state.feeTiers {
+		w.writeInt(int64(tier.ordererID))
+		w.writeString(tier.tier)
+	}
+	w.writeUint(uint64(len(state.instruments)))
+	for i := range state.instruments {
+		w.writeInstrument(&state.instruments[i])
+	}
+
+	return binary.BigEndian.AppendUint32(w.buf, crc32.ChecksumIEEE(w.buf))
+}
+
+func decodeState(snapshot []byte) (*engineState, error) {
+	if len(snapshot) < len(snapshotMagic)+4 || string(snapshot[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not an engine snapshot")
+	}
+	body := snapshot[:len(snapshot)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(snapshot[len(body):]) {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+
+	r := &snapshotReader{data: body[len(snapshotMagic):]}
+	if version := r.readUint(); r.err == nil && version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+
+	state := &engineState{
+		now:            r.readInt(),
+		inputSequence:  r.readUint(),
+		outputSequence: r.readUint(),
+	}
+	state.feeTiers = make([]feeTier, r.readCount())
+	for i := range state.feeTiers {
+		state.feeTiers[i] = feeTier{ordererID: int(r.readInt()), tier: r.readString()}
+	}
+	state.instruments = make([]instrumentState, r.readCount())
+	for i := range state.instruments {
+		r.readInstrument(&state.instruments[i])
+	}
+
+	if r.err == nil && len(r.data) > 0 {
+		r.err = errors.New("snapshot has trailing data")
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return state, nil
+}
+
+type snapshotWriter struct {
+	buf []byte
+}
+
+func (w *snapshotWriter) writeUint(v uint64) { w.buf = binary.AppendUvarint(w.buf, v) }
+
+func (w *snapshotWriter) writeInt(v int64) { w.buf = binary.AppendVarint(w.buf, v) }
+
+func (w *snapshotWriter) writeString(s string) {
+	w.writeUint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *snapshotWriter) writeBool(b bool) {
+	if b {
+		w.writeUint(1)
+	} else {
+		w.writeUint(0)
+	}
+}
+
+func (w *snapshotWriter) writeInstrument(state *instrumentState) {
+	w.writeConfig(&state.config)
+	for _, orders := range [][]BookOrder{state.bids, state.asks} {
+		w.writeUint(uint64(len(orders)))
+		for i := range orders {
+			w.writeBookOrder(&orders[i])
+		}
+	}
+	for _, stops := range [][]stopState{state.buyStopOrders, state.sellStopOrders} {
+		w.writeUint(uint64(len(stops)))
+		for i := range stops {
+			w.writeOrder(&stops[i].order)
+			w.writeInt(stops[i].priority)
+			w.writeUint(stops[i].sequence)
+		}
+	}
+	for _, stops := range [][]trailingState{state.buyTrailingStops, state.sellTrailingStops} {
+		w.writeUint(uint64(len(stops)))
+		for _, stop := range stops {
+			w.writeInt(int64(stop.orderID))
+			w.writeInt(stop.priority)
+			w.writeUint(stop.sequence)
+		}
+	}
+	w.writeUint(state.stopSequence)
+	w.writeUint(uint64(len(state.expiries)))
+	for _, due := range state.expiries {
+		w.writeInt(int64(due.orderID))
+		w.writeInt(due.expireAt)
+		w.writeUint(due.sequence)
+	}
+	w.writeUint(state.expirySequence)
+	w.writeInt(state.lastTradePrice)
+	w.writeWindow(state.vwapWindow)
+	w.writeWindow(state.volatilityWindow)
+	w.writeString(state.phase)
+	w.writeInt(state.phaseEndsAt)
+	w.writeInt(state.indicative.Price)
+	w.writeInt(int64(state.indicative.Quantity))
+	w.writeInt(int64(state.indicative.Imbalance))
+}
+
+func (w *snapshotWriter) writeConfig(c *OrderBookConfig) {
+	w.writeString(c.Symbol)
+	w.writeInt(c.MinTickSize)
+	w.writeUint(uint64(len(c.TickLadder)))
+	for _, band := range c.TickLadder {
+		w.writeInt(band.MinPrice)
+		w.writeInt(band.TickSize)
+	}
+	w.writeString(c.SelfTradePrevention)
+	w.writeInt(int64(c.LotSize))
+	w.writeInt(int64(c.MinQuantity))
+	w.writeInt(int64(c.MaxQuantity))
+	w.writeInt(c.MinPrice)
+	w.writeInt(c.MaxPrice)
+	w.writeInt(c.MinNotional)
+	w.writeInt(c.PriceBandBasisPoints)
+	w.writeString(c.PriceBandReference)
+	w.writeInt(c.VWAPWindow)
+	w.writeInt(c.VolatilityBasisPoints)
+	w.writeInt(c.VolatilityWindow)
+	w.writeInt(c.HaltDuration)
+	w.writeBool(c.OpeningAuction)
+	w.writeInt(c.AuctionDuration)
+	w.writeString(c.MatchingAlgorithm)
+	w.writeInt(int64(c.ProRataMinimumAllocation))
+
+	tiers := make([]string, 0, len(c.FeeSchedules))
+	for tier := range c.FeeSchedules {
+		tiers = append(tiers, tier)
+	}
+	sort.Strings(tiers)
+	w.writeUint(uint64(len(tiers)))
+	for _, tier := range tiers {
+		schedule := c.FeeSchedules[tier]
+		w.writeString(tier)
+		w.writeInt(schedule.MakerBasisPoints)
+		w.writeInt(schedule.TakerBasisPoints)
+		w.writeInt(schedule.MinimumFee)
+	}
+}
+
+func (w *snapshotWriter) writeBookOrder(o *BookOrder) {
+	w.writeInt(int64(o.ID))
+	w.writeInt(int64(o.OrdererID))
+	w.writeString(o.Side)
+	w.writeInt(o.Price)
+	w.writeInt(int64(o.Quantity))
+	w.writeBool(o.AllOrNone)
+	w.writeBool(o.PostOnly)
+	w.writeString(o.postOnlyMode)
+	w.writeString(o.selfTradePrevention)
+	w.writeInt(o.expireAt)
+	w.writeInt(int64(o.peak))
+	w.writeInt(int64(o.reserve))
+}
+
+func (w *snapshotWriter) writeOrder(o *Order) {
+	w.writeInt(int64(o.ID))
+	w.writeInt(int64(o.OrdererID))
+	w.writeString(o.Symbol)
+	w.writeString(o.Type)
+	w.writeString(o.Side)
+	w.writeInt(o.Price)
+	w.writeInt(int64(o.Quantity))
+	w.writeInt(o.TriggerPrice)
+	w.writeInt(o.TrailingOffset)
+	w.writeInt(o.TrailingBasisPoints)
+	w.writeInt(int64(o.DisplayQuantity))
+	w.writeString(o.PostOnlyMode)
+	w.writeString(o.SelfTradePrevention)
+	w.writeString(o.TimeInForce)
+	w.writeInt(o.ExpireAt)
+	w.writeInt(int64(o.ProtectionTicks))
+	w.writeInt(o.ProtectionBasisPoints)
+}
+
+func (w *snapshotWriter) writeWindow(window *tradeWindow) {
+	w.writeBool(window != nil)
+	if window == nil {
+		return
+	}
+	w.writeInt(window.length)
+	for _, points := range [][]tradePoint{window.trades, window.lows, window.highs} {
+		w.writeUint(uint64(len(points)))
+		for _, point := range points {
+			w.writeInt(point.time)
+			w.writeInt(point.price)
+			w.writeInt(int64(point.quantity))
+		}
+	}
+	w.writeInt(window.notional)
+	w.writeInt(window.quantity)
+}
+
+// snapshotReader decodes what snapshotWriter wrote. The first error sticks
+// and every later read returns zero values, so decoding only checks once.
+type snapshotReader struct {
+	data []byte
+	err  error
+}
+
+func (r *snapshotReader) readUint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		r.err = errSnapshotTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+func (r *snapshotReader) readInt() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = errSnapshotTruncated
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// readCount reads a length. Every element takes at least a byte, so a count
+// larger than what is left is corrupt and is refused before allocating.
+func (r *snapshotReader) readCount() int {
+	n := r.readUint()
+	if n > uint64(len(r.data)) {
+		if r.err == nil {
+			r.err = errSnapshotTruncated
+		}
+		return 0
+	}
+	return int(n)
+}
+
+func (r *snapshotReader) readString() string {
+	n := r.readCount()
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+func (r *snapshotReader) readBool() bool {
+	return r.readUint() != 0
+}
+
+func (r *snapshotReader) readInstrument(state *instrumentState) {
+	r.readConfig(&state.config)
+	for _, orders := range []*[]BookOrder{&state.bids, &state.asks} {
+		*orders = make([]BookOrder, r.readCount())
+		for i := range *orders {
+			r.readBookOrder(&(*orders)[i])
+		}
+	}
+	for _, stops := range []*[]stopState{&state.buyStopOrders, &state.sellStopOrders} {
+		*stops = make([]stopState, r.readCount())
+		for i := range *stops {
+			stop := &(*stops)[i]
+			r.readOrder(&stop.order)
+			stop.priority = r.readInt()
+			stop.sequence = r.readUint()
+		}
+	}
+	for _, stops := range []*[]trailingState{&state.buyTrailingStops, &state.sellTrailingStops} {
+		*stops = make([]trailingState, r.readCount())
+		for i := range *stops {
+			(*stops)[i] = trailingState{orderID: int(r.readInt()), priority: r.readInt(), sequence: r.readUint()}
+		}
+	}
+	state.stopSequence = r.readUint()
+	state.expiries = make([]expiry, r.readCount())
+	for i := range state.expiries {
+		state.expiries[i] = expiry{orderID: int(r.readInt()), expireAt: r.readInt(), sequence: r.readUint()}
+	}
+	state.expirySequence = r.readUint()
+	state.lastTradePrice = r.readInt()
+	state.vwapWindow = r.readWindow()
+	state.volatilityWindow = r.readWindow()
+	state.phase = r.readString()
+	state.phaseEndsAt = r.readInt()
+	state.indicative = IndicativeAuctionPrice{
+		Price:     r.readInt(),
+		Quantity:  int(r.readInt()),
+		Imbalance: int(r.readInt()),
+	}
+}
+
+func (r *snapshotReader) readConfig(c *OrderBookConfig) {
+	c.Symbol = r.readString()
+	c.MinTickSize = r.readInt()
+	if n := r.readCount(); n > 0 {
+		c.TickLadder = make([]TickBand, n)
+		for i := range c.TickLadder {
+			c.TickLadder[i] = TickBand{MinPrice: r.readInt(), TickSize: r.readInt()}
+		}
+	}
+	c.SelfTradePrevention = r.readString()
+	c.LotSize = int(r.readInt())
+	c.MinQuantity = int(r.readInt())
+	c.MaxQuantity = int(r.readInt())
+	c.MinPrice = r.readInt()
+	c.MaxPrice = r.readInt()
+	c.MinNotional = r.readInt()
+	c.PriceBandBasisPoints = r.readInt()
+	c.PriceBandReference = r.readString()
+	c.VWAPWindow = r.readInt()
+	c.VolatilityBasisPoints = r.readInt()
+	c.VolatilityWindow = r.readInt()
+	c.HaltDuration = r.readInt()
+	c.OpeningAuction = r.readBool()
+	c.AuctionDuration = r.readInt()
+	c.MatchingAlgorithm = r.readString()
+	c.ProRataMinimumAllocation = int(r.readInt())
+	if n := r.readCount(); n > 0 {
+		c.FeeSchedules = make(map[string]FeeSchedule, n)
+		for i := 0; i < n; i++ {
+			tier := r.readString()
+			c.FeeSchedules[tier] = FeeSchedule{
+				MakerBasisPoints: r.readInt(),
+				TakerBasisPoints: r.readInt(),
+				MinimumFee:       r.readInt(),
+			}
+		}
+	}
+}
+
+func (r *snapshotReader) readBookOrder(o *BookOrder) {
+	o.ID = int(r.readInt())
+	o.OrdererID = int(r.readInt())
+	o.Side = r.readString()
+	o.Price = r.readInt()
+	o.Quantity = int(r.readInt())
+	o.AllOrNone = r.readBool()
+	o.PostOnly = r.readBool()
+	o.postOnlyMode = r.readString()
+	o.selfTradePrevention = r.readString()
+	o.expireAt = r.readInt()
+	o.peak = int(r.readInt())
+	o.reserve = int(r.readInt())
+}
+
+func (r *snapshotReader) readOrder(o *Order) {
+	o.ID = int(r.readInt())
+	o.OrdererID = int(r.readInt())
+	o.Symbol = r.readString()
+	o.Type = r.readString()
+	o.Side = r.readString()
+	o.Price = r.readInt()
+	o.Quantity = int(r.readInt())
+	o.TriggerPrice = r.readInt()
+	o.TrailingOffset = r.readInt()
+	o.TrailingBasisPoints = r.readInt()
+	o.DisplayQuantity = int(r.readInt())
+	o.PostOnlyMode = r.readString()
+	o.SelfTradePrevention = r.readString()
+	o.TimeInForce = r.readString()
+	o.ExpireAt = r.readInt()
+	o.ProtectionTicks = int(r.readInt())
+	o.ProtectionBasisPoints = r.readInt()
+}
+
+func (r *snapshotReader) readWindow() *tradeWindow {
+	if !r.readBool() {
+		return nil
+	}
+	window := &tradeWindow{length: r.readInt()}
+	for _, points := range []*[]tradePoint{&window.trades, &window.lows, &window.highs} {
+		if n := r.readCount(); n > 0 {
+			*points = make([]tradePoint, n)
+			for i := range *points {
+				(*points)[i] = tradePoint{time: r.readInt(), price: r.readInt(), quantity: int(r.readInt())}
+			}
+		}
+	}
+	window.notional = r.readInt()
+	window.quantity = r.readInt()
+	return window
+}
@@ -0,0 +1,113 @@
+package matching
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Snapshot files are named after the input sequence they were taken at, so
+// the newest one sorts last.
+const (
+	snapshotFilePrefix = "snapshot-"
+	snapshotFileSuffix = ".bin"
+)
+
+// ErrNoSnapshot is returned by RestoreLatest when a directory holds no
+// snapshot that can be restored.
+var ErrNoSnapshot = errors.New("no valid snapshot")
+
+func snapshotFileName(sequence uint64) string {
+	return fmt.Sprintf("%s%020d%s", snapshotFilePrefix, sequence, snapshotFileSuffix)
+}
+
+// WriteSnapshotFile stores a snapshot taken at input sequence in dir. The
+// file is written under a temporary name, synced and then renamed, so a
+// crash never leaves a partial snapshot behind. It returns the file's path.
+func WriteSnapshotFile(dir string, sequence uint64, snapshot []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(dir, snapshotFilePrefix+"*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tempPath := file.Name()
+	_, err = file.Write(snapshot)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	path := filepath.Join(dir, snapshotFileName(sequence))
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+
+	// Sync the directory so the rename itself survives a crash.
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return path, nil
+}
+
+// snapshotFiles lists the snapshot files in dir by input sequence, newest
+// first.
+func snapshotFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type snapshotFile struct {
+		path     string
+		sequence uint64
+	}
+	var files []snapshotFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotFilePrefix) || !strings.HasSuffix(name, snapshotFileSuffix) {
+			continue
+		}
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, snapshotFilePrefix), snapshotFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		files = append(files, snapshotFile{path: filepath.Join(dir, name), sequence: sequence})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].sequence > files[j].sequence })
+
+	paths := make([]string, len(files))
+	for i, file := range files {
+		paths[i] = file.path
+	}
+	return paths, nil
+}
+
+// RestoreLatest restores the newest snapshot in dir that can be read back,
+// skipping any that are damaged. It returns ErrNoSnapshot if there is none.
+func RestoreLatest(outputBuffer *RingBuffer, dir string) (*MatchingEngine, error) {
+	paths, err := snapshotFiles(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, path := range paths {
+		snapshot, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if me, err := Restore(outputBuffer, snapshot); err == nil {
+			return me, nil
+		}
+	}
+	return nil, ErrNoSnapshot
+}
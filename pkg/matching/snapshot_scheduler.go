@@ -0,0 +1,140 @@
+package matching
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// A SnapshotPolicy tells the engine when to snapshot itself and where to keep
+// the files.
+type SnapshotPolicy struct {
+	Dir string
+	// A snapshot is taken once Inputs commands have been processed or
+	// Interval nanoseconds of engine time have passed since the last one,
+	// whichever comes first. Zero turns a trigger off.
+	Inputs   uint64
+	Interval int64
+	// Retain is how many snapshot files to keep; older ones are deleted.
+	// Zero keeps them all.
+	Retain int
+	// Taken, if set, is called after every snapshot with the input sequence
+	// it was taken at and its path, or with the error that stopped it being
+	// written. It runs on the snapshot writer's goroutine.
+	Taken func(sequence uint64, path string, err error)
+}
+
+// snapshotScheduler decides when a snapshot is due. The engine only captures
+// a copy of its state; encoding and writing it happen on the writer
+// goroutine, so matching is not held up by the disk.
+type snapshotScheduler struct {
+	policy       SnapshotPolicy
+	lastSequence uint64
+	lastTime     int64
+	pending      chan *engineState
+	done         chan struct{}
+}
+
+// ScheduleSnapshots makes the engine snapshot itself according to policy
+// until StopSnapshots is called. Like AddInstrument, it must not be called
+// while Run is processing commands.
+func (me *MatchingEngine) ScheduleSnapshots(policy SnapshotPolicy) error {
+	if policy.Dir == "" {
+		return errors.New("snapshot policy: missing directory")
+	}
+	if policy.Inputs == 0 && policy.Interval <= 0 {
+		return errors.New("snapshot policy: needs an input count or an interval")
+	}
+	if policy.Interval < 0 || policy.Retain < 0 {
+		return errors.New("snapshot policy: interval and retention must not be negative")
+	}
+	if me.snapshots != nil {
+		return errors.New("snapshot policy: snapshots are already scheduled")
+	}
+
+	s := &snapshotScheduler{
+		policy:       policy,
+		lastSequence: me.inputSequence,
+		lastTime:     me.now,
+		pending:      make(chan *engineState, 1),
+		done:         make(chan struct{}),
+	}
+	go s.run()
+	me.snapshots = s
+	return nil
+}
+
+// StopSnapshots stops taking snapshots and waits for the ones already
+// captured to be written.
+func (me *MatchingEngine) StopSnapshots() {
+	if me.snapshots == nil {
+		return
+	}
+	close(me.snapshots.pending)
+	<-me.snapshots.done
+	me.snapshots = nil
+}
+
+// snapshotIfDue runs after every command. While the writer is still busy
+// with an earlier snapshot the next one is put off, and taken after a later
+// command instead, rather than making the engine wait.
+func (me *MatchingEngine) snapshotIfDue() {
+	s := me.snapshots
+	if s == nil {
+		return
+	}
+	if s.lastTime == 0 {
+		// Engine time starts at the first clock tick, not at zero.
+		s.lastTime = me.now
+	}
+	due := (s.policy.Inputs > 0 && me.inputSequence-s.lastSequence >= s.policy.Inputs) ||
+		(s.policy.Interval > 0 && me.now-s.lastTime >= s.policy.Interval)
+	if !due || len(s.pending) == cap(s.pending) {
+		return
+	}
+	s.pending <- me.captureState()
+	s.lastSequence = me.inputSequence
+	s.lastTime = me.now
+}
+
+func (s *snapshotScheduler) run() {
+	defer close(s.done)
+	for state := range s.pending {
+		path, err := WriteSnapshotFile(s.policy.Dir, state.inputSequence, state.encode())
+		if err == nil {
+			err = pruneSnapshots(s.policy.Dir, s.policy.Retain)
+		}
+		if s.policy.Taken != nil {
+			s.policy.Taken(state.inputSequence, path, err)
+		}
+	}
+}
+
+// pruneSnapshots deletes all but the newest retain snapshot files in dir,
+// along with temporary files left by a crash during a write.
+func pruneSnapshots(dir string, retain int) error {
+	temporary, err := filepath.Glob(filepath.Join(dir, snapshotFilePrefix+"*.tmp"))
+	if err != nil {
+		return err
+	}
+	for _, path := range temporary {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	if retain == 0 {
+		return nil
+	}
+	paths, err := snapshotFiles(dir)
+	if err != nil {
+		return err
+	}
+	for len(paths) > retain {
+		if err := os.Remove(paths[len(paths)-1]); err != nil {
+			return err
+		}
+		paths = paths[:len(paths)-1]
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package matching
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestMatchingEngine_ScheduleSnapshots(t *testing.T) {
+	type taken struct {
+		sequence uint64
+		path     string
+		err      error
+	}
+
+	schedule := func(t *testing.T, me *MatchingEngine, policy SnapshotPolicy) chan taken {
+		t.Helper()
+		snapshots := make(chan taken, 16)
+		policy.Taken = func(sequence uint64, path string, err error) {
+			snapshots <- taken{sequence, path, err}
+		}
+		if err := me.ScheduleSnapshots(policy); err != nil {
+			t.Fatal(err)
+		}
+		return snapshots
+	}
+
+	placeOrder := func(me *MatchingEngine, id int) {
+		me.PlaceOrder(&Order{ID: id, Type: "limit", Side: "buy", Price: int64(50+id) * PricePrecision, Quantity: 1})
+	}
+
+	t.Run("should snapshot every N inputs and keep the newest", func(t *testing.T) {
+		dir := t.TempDir()
+		me := NewMatchingEngine(nil)
+		snapshots := schedule(t, me, SnapshotPolicy{Dir: dir, Inputs: 3, Retain: 2})
+
+		var states [][]byte
+		for id := 1; id <= 9; id++ {
+			placeOrder(me, id)
+			if id%3 != 0 {
+				continue
+			}
+			// Wait for each write, so the writer is idle when the next
+			// snapshot comes due.
+			snapshot := <-snapshots
+			if snapshot.err != nil || snapshot.sequence != uint64(id) {
+				t.Fatalf("Expected a snapshot at input %d, got %+v", id, snapshot)
+			}
+			states = append(states, me.Snapshot())
+		}
+		me.StopSnapshots()
+
+		paths, err := snapshotFiles(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(paths) != 2 {
+			t.Fatalf("Expected 2 snapshots to be kept, got %v", paths)
+		}
+		data, err := os.ReadFile(paths[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, states[2]) {
+			t.Error("Expected the newest file to hold the state at input 9")
+		}
+	})
+
+	t.Run("should snapshot on engine time", func(t *testing.T) {
+		me := NewMatchingEngine(nil)
+		me.AdvanceClock(&ClockTick{Time: 1_000})
+		snapshots := schedule(t, me, SnapshotPolicy{Dir: t.TempDir(), Interval: 500})
+
+		me.AdvanceClock(&ClockTick{Time: 1_200})
+		placeOrder(me, 1)
+		me.AdvanceClock(&ClockTick{Time: 1_500})
+		me.StopSnapshots()
+
+		if len(snapshots) != 1 {
+			t.Fatalf("Expected one snapshot, got %d", len(snapshots))
+		}
+		if snapshot := <-snapshots; snapshot.sequence != 4 {
+			t.Errorf("Expected the snapshot after the tick at input 4, got %+v", snapshot)
+		}
+	})
+
+	t.Run("should capture state at the time the snapshot was due", func(t *testing.T) {
+		dir := t.TempDir()
+		me := NewMatchingEngine(nil)
+		snapshots := schedule(t, me, SnapshotPolicy{Dir: dir, Inputs: 2})
+
+		placeOrder(me, 1)
+		placeOrder(me, 2)
+		expected := me.Snapshot()
+		// Orders placed while the writer may still be busy must not leak
+		// into the snapshot.
+		placeOrder(me, 3)
+		me.StopSnapshots()
+
+		snapshot := <-snapshots
+		data, err := os.ReadFile(snapshot.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Error("Expected the snapshot to hold the state at input 2")
+		}
+	})
+
+	t.Run("should reject invalid policies", func(t *testing.T) {
+		me := NewMatchingEngine(nil)
+		for _, policy := range []SnapshotPolicy{
+			{Inputs: 10},
+			{Dir: t.TempDir()},
+			{Dir: t.TempDir(), Inputs: 10, Retain: -1},
+		} {
+			if err := me.ScheduleSnapshots(policy); err == nil {
+				t.Errorf("Expected %+v to be rejected", policy)
+			}
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package matching
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchingEngine_Snapshot(t *testing.T) {
+	// newEngine builds an engine with something in every part of its state:
+	// resting and iceberg orders, all kinds of stop, expiries, a VWAP window,
+	// fee tiers and an instrument in a call auction.
+	newEngine := func() (*MatchingEngine, *RingBuffer) {
+		outputBuffer := NewRingBuffer(4096)
+		me := NewMultiInstrumentEngine(outputBuffer, []*OrderBookConfig{
+			{
+				Symbol:               "BTC-USD",
+				MinTickSize:          1,
+				TickLadder:           []TickBand{{MinPrice: 1000 * PricePrecision, TickSize: 5}},
+				PriceBandBasisPoints: 1000,
+				PriceBandReference:   PriceReferenceVWAP,
+				VWAPWindow:           1000,
+				FeeSchedules:         map[string]FeeSchedule{"": {TakerBasisPoints: 5}, "vip": {MakerBasisPoints: -1, TakerBasisPoints: 3}},
+			},
+			{Symbol: "ETH-USD", MinTickSize: 1, OpeningAuction: true},
+		})
+		me.SetFeeTier(7, "vip")
+		me.AdvanceClock(&ClockTick{Time: 100})
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Symbol: "BTC-USD", Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10, DisplayQuantity: 3})
+		me.PlaceOrder(&Order{ID: 2, OrdererID: 8, Symbol: "BTC-USD", Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 3, OrdererID: 8, Symbol: "BTC-USD", Type: "limit", Side: "sell", Price: 101 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 4, OrdererID: 9, Symbol: "BTC-USD", Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 5, TimeInForce: "gtd", ExpireAt: 500})
+		me.PlaceOrder(&Order{ID: 5, OrdererID: 9, Symbol: "BTC-USD", Type: "limit", Side: "buy", Price: 98 * PricePrecision, Quantity: 5})
+		me.PlaceOrder(&Order{ID: 6, OrdererID: 9, Symbol: "BTC-USD", Type: "market", Side: "buy", Quantity: 2})
+		me.PlaceOrder(&Order{ID: 7, OrdererID: 9, Symbol: "BTC-USD", Type: "stop-loss", Side: "sell", TriggerPrice: 99 * PricePrecision, Quantity: 3})
+		me.PlaceOrder(&Order{ID: 8, OrdererID: 9, Symbol: "BTC-USD", Type: "trailing-stop", Side: "buy", TrailingOffset: PricePrecision, Quantity: 2})
+		me.PlaceOrder(&Order{ID: 9, OrdererID: 9, Symbol: "BTC-USD", Type: "stop-limit", Side: "buy", TriggerPrice: 101 * PricePrecision, Price: 101 * PricePrecision, Quantity: 1, TimeInForce: "gtd", ExpireAt: 800})
+		me.PlaceOrder(&Order{ID: 10, OrdererID: 9, Symbol: "ETH-USD", Type: "limit", Side: "buy", Price: 20 * PricePrecision, Quantity: 4})
+		me.PlaceOrder(&Order{ID: 11, OrdererID: 8, Symbol: "ETH-USD", Type: "limit", Side: "sell", Price: 19 * PricePrecision, Quantity: 3})
+		return me, outputBuffer
+	}
+
+	// continueTrading drives an engine through trades that fire stops, an
+	// expiry, an amend and the auction uncross.
+	continueTrading := func(me *MatchingEngine) {
+		me.PlaceOrder(&Order{ID: 20, OrdererID: 8, Symbol: "BTC-USD", Type: "market", Side: "buy", Quantity: 9})
+		me.AmendOrder(&AmendRequest{OrderID: 5, OrdererID: 9, Symbol: "BTC-USD", Price: 99 * PricePrecision, Quantity: 4})
+		me.AdvanceClock(&ClockTick{Time: 600})
+		me.PlaceOrder(&Order{ID: 21, OrdererID: 8, Symbol: "BTC-USD", Type: "market", Side: "sell", Quantity: 6})
+		me.CancelOrder(&CancelRequest{OrderID: 9, OrdererID: 9, Symbol: "BTC-USD"})
+		me.ChangePhase(&PhaseChange{Symbol: "ETH-USD", Phase: PhaseContinuous})
+		me.TakeSnapshot()
+	}
+
+	drain := func(outputBuffer *RingBuffer) []string {
+		var events []string
+		for {
+			event, ok := outputBuffer.Pop()
+			if !ok {
+				return events
+			}
+			events = append(events, fmt.Sprintf("%d/%d %s %T%+v", event.Sequence, event.OutputSequence, event.Symbol, event.Report, event.Report))
+		}
+	}
+
+	t.Run("should restore to the same bytes", func(t *testing.T) {
+		me, _ := newEngine()
+		snapshot := me.Snapshot()
+
+		restored, err := Restore(nil, snapshot)
+		if err != nil {
+			t.Fatalf("Expected the snapshot to restore, got %v", err)
+		}
+		if !bytes.Equal(restored.Snapshot(), snapshot) {
+			t.Error("Expected the restored engine to snapshot to the same bytes")
+		}
+		if restored.InputSequence() != me.InputSequence() || restored.OutputSequence() != me.OutputSequence() {
+			t.Errorf("Expected sequences %d/%d, got %d/%d", me.InputSequence(), me.OutputSequence(), restored.InputSequence(), restored.OutputSequence())
+		}
+	})
+
+	t.Run("should behave identically after a restore", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		drain(outputBuffer)
+		restoredBuffer := NewRingBuffer(4096)
+		restored, err := Restore(restoredBuffer, me.Snapshot())
+		if err != nil {
+			t.Fatalf("Expected the snapshot to restore, got %v", err)
+		}
+
+		continueTrading(me)
+		continueTrading(restored)
+
+		expected, got := drain(outputBuffer), drain(restoredBuffer)
+		if len(expected) == 0 {
+			t.Fatal("Expected the original engine to publish events")
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("Expected %d events, got %d:\n%v\n%v", len(expected), len(got), expected, got)
+		}
+		for i := range expected {
+			if got[i] != expected[i] {
+				t.Errorf("Expected event %d to be %s, got %s", i, expected[i], got[i])
+			}
+		}
+		if !bytes.Equal(restored.Snapshot(), me.Snapshot()) {
+			t.Error("Expected both engines to end in the same state")
+		}
+	})
+
+	t.Run("should reject damaged snapshots", func(t *testing.T) {
+		me, _ := newEngine()
+		snapshot := me.Snapshot()
+
+		corrupted := append([]byte(nil), snapshot...)
+		corrupted[len(corrupted)/2] ^= 0xff
+		versioned := append([]byte(nil), snapshot[:len(snapshotMagic)]...)
+		versioned = append(versioned, snapshotVersion+1)
+		versioned = append(versioned, snapshot[len(snapshotMagic)+1:len(snapshot)-4]...)
+		versioned = binary.BigEndian.AppendUint32(versioned, crc32.ChecksumIEEE(versioned))
+
+		tests := map[string][]byte{
+			"empty":       nil,
+			"wrong magic": append([]byte("XXXX"), snapshot[4:]...),
+			"truncated":   snapshot[:len(snapshot)-10],
+			"corrupted":   corrupted,
+			"new version": versioned,
+		}
+		for name, data := range tests {
+			t.Run(name, func(t *testing.T) {
+				if _, err := Restore(nil, data); err == nil {
+					t.Error("Expected the snapshot to be rejected")
+				}
+			})
+		}
+	})
+}
+
+func TestRestoreLatest(t *testing.T) {
+	snapshotAt := func(orders int) (*MatchingEngine, []byte) {
+		me := NewMatchingEngine(nil)
+		for i := 1; i <= orders; i++ {
+			me.PlaceOrder(&Order{ID: i, Type: "limit", Side: "buy", Price: int64(90+i) * PricePrecision, Quantity: 1})
+		}
+		return me, me.Snapshot()
+	}
+
+	t.Run("should restore the newest valid snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		for _, orders := range []int{1, 3, 2} {
+			me, snapshot := snapshotAt(orders)
+			if _, err := WriteSnapshotFile(dir, me.InputSequence(), snapshot); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// A damaged newer snapshot is skipped.
+		if err := os.WriteFile(filepath.Join(dir, snapshotFileName(4)), []byte("garbage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		me, err := RestoreLatest(nil, dir)
+		if err != nil {
+			t.Fatalf("Expected a snapshot to restore, got %v", err)
+		}
+		if me.InputSequence() != 3 {
+			t.Errorf("Expected the snapshot at input sequence 3, got %d", me.InputSequence())
+		}
+	})
+
+	t.Run("should report when there is no snapshot", func(t *testing.T) {
+		for _, dir := range []string{t.TempDir(), filepath.Join(t.TempDir(), "missing")} {
+			if _, err := RestoreLatest(nil, dir); !errors.Is(err, ErrNoSnapshot) {
+				t.Errorf("Expected ErrNoSnapshot for %s, got %v", dir, err)
+			}
+		}
+	})
+
+	t.Run("should leave no temporary files behind", func(t *testing.T) {
+		dir := t.TempDir()
+		me, snapshot := snapshotAt(1)
+		path, err := WriteSnapshotFile(dir, me.InputSequence(), snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 1 || filepath.Join(dir, entries[0].Name()) != path {
+			t.Errorf("Expected only %s in the directory, got %v", path, entries)
+		}
+	})
+}
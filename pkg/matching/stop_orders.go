@@ -0,0 +1,262 @@
+package matching
+
+import (
+	"container/heap"
+)
+
+// A StopLossOrder is something we manage in a priority queue.
+type StopLossOrder struct {
+	value    *Order // The value of the item; arbitrary.
+	priority int64  // The priority of the item in the queue.
+	sequence uint64 // Arrival order, so stops at the same trigger fire first-in first-out.
+	// The index is needed by update and is maintained by the heap.Interface methods.
+	index int // The index of the item in the heap.
+}
+
+// A StopLossQueue implements heap.Interface and holds StopLossOrders.
+type StopLossQueue []*StopLossOrder
+
+func (pq StopLossQueue) Len() int { return len(pq) }
+
+func (pq StopLossQueue) Less(i, j int) bool {
+	// We want Pop to give us the highest, not lowest, priority so we use greater than here.
+	if pq[i].priority == pq[j].priority {
+		return pq[i].sequence < pq[j].sequence
+	}
+	return pq[i].priority > pq[j].priority
+}
+
+func (pq StopLossQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
+}
+
+func (pq *StopLossQueue) Push(x interface{}) {
+	n := len(*pq)
+	item := x.(*StopLossOrder)
+	item.index = n
+	*pq = append(*pq, item)
+}
+
+func (pq *StopLossQueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil  // avoid memory leak
+	item.index = -1 // for safety
+	*pq = old[0 : n-1]
+	return item
+}
+
+// addStopOrder parks a stop order until the last trade price reaches its
+// trigger. A stop placed beyond the last trade is released by the next
+// triggerStopLossOrders straight away. Sell stops fire when the price falls to the trigger, so the highest
+// trigger sits on top of sellStopOrders; buy stops fire when the price rises to
+// it, so the lowest trigger sits on top of buyStopOrders.
+func (in *instrument) addStopOrder(order *Order) {
+	if order.TriggerPrice == 0 && order.Type == "stop-loss" {
+		order.TriggerPrice = order.Price
+	}
+	var watermark int64
+	if order.Type == "trailing-stop" {
+		// A trailing stop is anchored at the last trade, or at its own price
+		// if nothing has traded yet.
+		watermark = in.lastTradePrice
+		if watermark == 0 {
+			watermark = order.Price
+		}
+		order.TriggerPrice = trailingTrigger(order, watermark)
+	}
+	if order.TriggerPrice <= 0 {
+		in.rejectOrder(order, RejectInvalidTrigger)
+		return
+	}
+	in.accept(order)
+	in.scheduleExpiry(order)
+
+	in.stopSequence++
+	item := &StopLossOrder{
+		value:    order,
+		priority: order.TriggerPrice,
+		sequence: in.stopSequence,
+	}
+	if order.Side == "buy" {
+		item.priority = -order.TriggerPrice
+		heap.Push(in.buyStopOrders, item)
+	} else {
+		heap.Push(in.sellStopOrders, item)
+	}
+	in.stopOrders[order.ID] = item
+
+	if order.Type == "trailing-stop" {
+		in.trackTrailingStop(order, watermark)
+	}
+}
+
+// removeStopOrder takes a pending stop out of every queue it is in.
+func (in *instrument) removeStopOrder(item *StopLossOrder) {
+	if item.value.Side == "buy" {
+		heap.Remove(in.buyStopOrders, item.index)
+	} else {
+		heap.Remove(in.sellStopOrders, item.index)
+	}
+	delete(in.stopOrders, item.value.ID)
+	in.untrackTrailingStop(item.value)
+}
+
+// triggerStopLossOrders releases stop orders once the last trade price has
+// reached them. It runs after the incoming order has finished matching so a
+// triggered stop never trades against a maker that is still being filled, and
+// it keeps checking because triggered orders can move the price further.
+// Stops are only released in continuous trading, and before each release it
+// checks for a volatility halt, which stops the cascade until trading resumes.
+func (in *instrument) triggerStopLossOrders() {
+	for in.phase == PhaseContinuous && in.lastTradePrice > 0 && !in.checkVolatility() {
+		currentPrice := in.lastTradePrice
+
+		// Trigger sell stop orders
+		if in.sellStopOrders.Len() > 0 && (*in.sellStopOrders)[0].priority >= currentPrice {
+			in.activateStopOrder(heap.Pop(in.sellStopOrders).(*StopLossOrder).value)
+			continue
+		}
+
+		// Trigger buy stop orders
+		if in.buyStopOrders.Len() > 0 && -(*in.buyStopOrders)[0].priority <= currentPrice {
+			in.activateStopOrder(heap.Pop(in.buyStopOrders).(*StopLossOrder).value)
+			continue
+		}
+
+		return
+	}
+}
+
+// activateStopOrder turns a triggered stop into a live order: a stop-loss or
+// trailing stop becomes a market order, a stop-limit becomes a limit order at its Price that
+// rests if it does not fill.
+func (in *instrument) activateStopOrder(stopOrder *Order) {
+	delete(in.stopOrders, stopOrder.ID)
+	in.untrackTrailingStop(stopOrder)
+	order := &Order{
+		ID:        stopOrder.ID,
+		OrdererID: stopOrder.OrdererID,
+		Type:      "market",
+		Side:      stopOrder.Side,
+		Quantity:  stopOrder.Quantity,
+
+		SelfTradePrevention:   stopOrder.SelfTradePrevention,
+		TimeInForce:           stopOrder.TimeInForce,
+		ExpireAt:              stopOrder.ExpireAt,
+		ProtectionTicks:       stopOrder.ProtectionTicks,
+		ProtectionBasisPoints: stopOrder.ProtectionBasisPoints,
+	}
+	if stopOrder.Type == "stop-limit" {
+		order.Type = "limit"
+		order.Price = stopOrder.Price
+	}
+	if reason := in.validateTriggeredOrder(order); reason != "" {
+		in.rejectOrder(order, reason)
+		return
+	}
+	if order.Type == "limit" {
+		in.matchLimitOrder(order)
+		return
+	}
+	in.matchMarketOrder(order)
+}
+
+// TrailingStopUpdated is published when a trailing stop is placed and every
+// time its trigger price moves with the market.
+type TrailingStopUpdated struct {
+	OrderID      int
+	OrdererID    int
+	Side         string
+	TriggerPrice int64
+}
+
+// trailingTrigger returns the trigger price of a trailing stop given the best
+// price seen since it was placed: below the high for a sell, above the low for
+// a buy.
+func trailingTrigger(order *Order, watermark int64) int64 {
+	offset := order.TrailingOffset
+	if order.TrailingBasisPoints > 0 {
+		offset = watermark * order.TrailingBasisPoints / 10000
+	}
+	if offset <= 0 {
+		return 0
+	}
+	if order.Side == "buy" {
+		return watermark + offset
+	}
+	return watermark - offset
+}
+
+func (in *instrument) trackTrailingStop(order *Order, watermark int64) {
+	item := &StopLossOrder{value: order, sequence: in.stopSequence}
+	if order.Side == "buy" {
+		// The highest low-water mark is the first to move when the price falls.
+		item.priority = watermark
+		heap.Push(in.buyTrailingStops, item)
+	} else {
+		// The lowest high-water mark is the first to move when the price rises.
+		item.priority = -watermark
+		heap.Push(in.sellTrailingStops, item)
+	}
+	in.trailingStops[order.ID] = item
+	in.publishTrailingStop(order)
+}
+
+func (in *instrument) untrackTrailingStop(order *Order) {
+	item, ok := in.trailingStops[order.ID]
+	if !ok {
+		return
+	}
+	if order.Side == "buy" {
+		heap.Remove(in.buyTrailingStops, item.index)
+	} else {
+		heap.Remove(in.sellTrailingStops, item.index)
+	}
+	delete(in.trailingStops, order.ID)
+}
+
+// ratchetTrailingStops moves the trigger of every trailing stop whose water
+// mark has been passed by price. Only those stops are touched, each at
+// O(log n), so thousands of idle trailing stops cost nothing per trade.
+func (in *instrument) ratchetTrailingStops(price int64) {
+	for in.sellTrailingStops.Len() > 0 && -(*in.sellTrailingStops)[0].priority < price {
+		item := (*in.sellTrailingStops)[0]
+		item.priority = -price
+		heap.Fix(in.sellTrailingStops, 0)
+		in.retrail(item.value, price)
+	}
+	for in.buyTrailingStops.Len() > 0 && (*in.buyTrailingStops)[0].priority > price {
+		item := (*in.buyTrailingStops)[0]
+		item.priority = price
+		heap.Fix(in.buyTrailingStops, 0)
+		in.retrail(item.value, price)
+	}
+}
+
+// retrail re-keys a trailing stop in its trigger queue after its water mark
+// moved.
+func (in *instrument) retrail(order *Order, watermark int64) {
+	order.TriggerPrice = trailingTrigger(order, watermark)
+	item := in.stopOrders[order.ID]
+	if order.Side == "buy" {
+		item.priority = -order.TriggerPrice
+		heap.Fix(in.buyStopOrders, item.index)
+	} else {
+		item.priority = order.TriggerPrice
+		heap.Fix(in.sellStopOrders, item.index)
+	}
+	in.publishTrailingStop(order)
+}
+
+func (in *instrument) publishTrailingStop(order *Order) {
+	in.publish(TrailingStopUpdated{
+		OrderID:      order.ID,
+		OrdererID:    order.OrdererID,
+		Side:         order.Side,
+		TriggerPrice: order.TriggerPrice,
+	})
+}
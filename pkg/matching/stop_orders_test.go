@@ -0,0 +1,157 @@
+package matching
+
+import (
+	"testing"
+)
+
+func TestMatchingEngine_PlaceTrailingStopOrder(t *testing.T) {
+	trade := func(me *MatchingEngine, id int, price int64) {
+		me.PlaceOrder(&Order{ID: id, Type: "limit", Side: "buy", Price: price, Quantity: 1})
+		me.PlaceOrder(&Order{ID: id + 1, Type: "limit", Side: "sell", Price: price, Quantity: 1})
+	}
+
+	t.Run("should ratchet a sell trailing stop up with the market", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		trade(me, 100, 100*PricePrecision)
+		outputBuffer.Pop()
+
+		me.PlaceOrder(&Order{ID: 1, Type: "trailing-stop", Side: "sell", TrailingOffset: 2 * PricePrecision, Quantity: 5})
+		event, _ := outputBuffer.Pop()
+		if updated, ok := event.Report.(TrailingStopUpdated); !ok || updated.TriggerPrice != 98*PricePrecision {
+			t.Fatalf("Expected an initial trigger of %d, but got %v", 98*PricePrecision, event.Report)
+		}
+
+		trade(me, 102, 103*PricePrecision)
+		outputBuffer.Pop()
+		event, _ = outputBuffer.Pop()
+		if updated, ok := event.Report.(TrailingStopUpdated); !ok || updated.TriggerPrice != 101*PricePrecision {
+			t.Fatalf("Expected the trigger to move to %d, but got %v", 101*PricePrecision, event.Report)
+		}
+
+		trade(me, 104, 102*PricePrecision)
+		outputBuffer.Pop()
+		if outputBuffer.Size() != 0 {
+			t.Fatalf("Expected the trigger not to move down, got %d more events", outputBuffer.Size())
+		}
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 1 {
+			t.Fatalf("Expected the stop to be pending, got %d", me.instruments[DefaultSymbol].sellStopOrders.Len())
+		}
+
+		trade(me, 106, 101*PricePrecision)
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 0 || me.instruments[DefaultSymbol].sellTrailingStops.Len() != 0 {
+			t.Errorf("Expected the stop to trigger at %d", 101*PricePrecision)
+		}
+	})
+
+	t.Run("should trail a buy stop by a percentage", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		trade(me, 100, 100*PricePrecision)
+		outputBuffer.Pop()
+
+		me.PlaceOrder(&Order{ID: 1, Type: "trailing-stop", Side: "buy", TrailingBasisPoints: 500, Quantity: 5})
+		outputBuffer.Pop()
+
+		trade(me, 102, 80*PricePrecision)
+		outputBuffer.Pop()
+		event, _ := outputBuffer.Pop()
+		if updated, ok := event.Report.(TrailingStopUpdated); !ok || updated.TriggerPrice != 84*PricePrecision {
+			t.Fatalf("Expected the trigger to move to %d, but got %v", 84*PricePrecision, event.Report)
+		}
+
+		trade(me, 104, 84*PricePrecision)
+		if me.instruments[DefaultSymbol].buyStopOrders.Len() != 0 || me.instruments[DefaultSymbol].buyTrailingStops.Len() != 0 {
+			t.Errorf("Expected the stop to trigger at %d", 84*PricePrecision)
+		}
+	})
+
+	t.Run("should only re-key stops whose water mark was passed", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		trade(me, 100, 110*PricePrecision)
+		me.PlaceOrder(&Order{ID: 1, Type: "trailing-stop", Side: "sell", TrailingOffset: PricePrecision, Quantity: 1})
+		trade(me, 102, 100*PricePrecision)
+		me.PlaceOrder(&Order{ID: 2, Type: "trailing-stop", Side: "sell", TrailingOffset: PricePrecision, Quantity: 1})
+		for outputBuffer.Size() > 0 {
+			outputBuffer.Pop()
+		}
+
+		// Order 1 went off at 100; order 2 is anchored at 100 and should move
+		// on a trade at 105.
+		if _, ok := me.instruments[DefaultSymbol].stopOrders[1]; ok {
+			t.Fatal("Expected order 1 to have triggered")
+		}
+		trade(me, 104, 105*PricePrecision)
+		outputBuffer.Pop()
+		event, _ := outputBuffer.Pop()
+		if updated, ok := event.Report.(TrailingStopUpdated); !ok || updated.OrderID != 2 || updated.TriggerPrice != 104*PricePrecision {
+			t.Errorf("Expected order 2 to trail to %d, but got %v", 104*PricePrecision, event.Report)
+		}
+	})
+
+	t.Run("should cancel a trailing stop", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "trailing-stop", Side: "sell", Price: 100 * PricePrecision, TrailingOffset: PricePrecision, Quantity: 1})
+		me.CancelOrder(&CancelRequest{OrderID: 1, OrdererID: 7})
+
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 0 || me.instruments[DefaultSymbol].sellTrailingStops.Len() != 0 {
+			t.Error("Expected the trailing stop to be removed from both queues")
+		}
+	})
+}
+
+func TestMatchingEngine_PlaceTriggeredStopOrder(t *testing.T) {
+	setup := func() (*testOutput, *MatchingEngine) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngine(outputBuffer.buffer)
+		me.PlaceOrder(&Order{ID: 100, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 101, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 1})
+		me.PlaceOrder(&Order{ID: 102, Type: "limit", Side: "buy", Price: 99 * PricePrecision, Quantity: 5})
+		for outputBuffer.Size() > 0 {
+			outputBuffer.Pop()
+		}
+		return outputBuffer, me
+	}
+
+	t.Run("should release a sell stop above the last trade at once", func(t *testing.T) {
+		outputBuffer, me := setup()
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-loss", Side: "sell", TriggerPrice: 105 * PricePrecision, Quantity: 5})
+
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 0 {
+			t.Fatalf("Expected the stop to be released, got %d pending", me.instruments[DefaultSymbol].sellStopOrders.Len())
+		}
+		event, ok := outputBuffer.Pop()
+		if trade, isTrade := event.Report.(Trade); !ok || !isTrade || trade.Price != 99*PricePrecision || trade.Quantity != 5 {
+			t.Errorf("Expected a trade of 5 at %d, but got %v", 99*PricePrecision, event.Report)
+		}
+	})
+
+	t.Run("should release a buy stop-limit below the last trade at once", func(t *testing.T) {
+		outputBuffer, me := setup()
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-limit", Side: "buy", TriggerPrice: 95 * PricePrecision, Price: 101 * PricePrecision, Quantity: 2})
+
+		if me.instruments[DefaultSymbol].buyStopOrders.Len() != 0 {
+			t.Fatalf("Expected the stop to be released, got %d pending", me.instruments[DefaultSymbol].buyStopOrders.Len())
+		}
+		if outputBuffer.Size() != 0 {
+			t.Fatalf("Expected the released stop-limit to rest, got %d events", outputBuffer.Size())
+		}
+		if best := me.GetOrderBook().BestBid(); best == nil || best.ID != 1 {
+			t.Errorf("Expected order 1 to rest as the best bid, got %v", best)
+		}
+	})
+
+	t.Run("should keep a stop the last trade has not reached pending", func(t *testing.T) {
+		outputBuffer, me := setup()
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-loss", Side: "sell", TriggerPrice: 95 * PricePrecision, Quantity: 5})
+
+		if me.instruments[DefaultSymbol].sellStopOrders.Len() != 1 {
+			t.Errorf("Expected the stop to be pending, got %d", me.instruments[DefaultSymbol].sellStopOrders.Len())
+		}
+		if outputBuffer.Size() != 0 {
+			t.Errorf("Expected no events, got %d", outputBuffer.Size())
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package matching
+
+// Trading phases an instrument moves through.
+const (
+	PhaseContinuous = "continuous" // Orders match as they arrive.
+	PhaseAuction    = "auction"    // Orders collect on the book and match at one price when the auction uncrosses.
+	PhaseHalted     = "halted"     // New orders and amends are rejected; cancels are still accepted.
+)
+
+const (
+	HaltReasonVolatility = "volatility"
+	HaltReasonRequested  = "requested"
+)
+
+const RejectInvalidPhase = "invalid-phase"
+
+// A PhaseChange moves an instrument to another trading phase. Moving an
+// auction on to continuous trading uncrosses it, and moving a halted
+// instrument on lifts the halt.
+type PhaseChange struct {
+	Symbol string
+	Phase  string
+}
+
+// PhaseChangeRejected is published when a PhaseChange could not be applied.
+type PhaseChangeRejected struct {
+	Phase  string
+	Reason string
+}
+
+// TradingHalted is published when an instrument is halted. Low and High are
+// the trade prices that set off a volatility halt. ResumeAt is the engine
+// time at which the halt ends, or 0 if it lasts until a PhaseChange.
+type TradingHalted struct {
+	Reason   string
+	Low      int64
+	High     int64
+	ResumeAt int64
+}
+
+// TradingResumed is published when a halt ends straight into continuous
+// trading.
+type TradingResumed struct{}
+
+// AuctionStarted is published when an instrument enters a call auction.
+// UncrossAt is the engine time of the uncross, or 0 if the auction runs until
+// a PhaseChange.
+type AuctionStarted struct {
+	UncrossAt int64
+}
+
+func (PhaseChangeRejected) executionReport() {}
+func (TradingHalted) executionReport()       {}
+func (TradingResumed) executionReport()      {}
+func (AuctionStarted) executionReport()      {}
+
+// changePhase applies a PhaseChange command.
+func (in *instrument) changePhase(change *PhaseChange) {
+	switch change.Phase {
+	case PhaseContinuous:
+		switch in.phase {
+		case PhaseAuction:
+			in.uncross()
+		case PhaseHalted:
+			in.resume()
+		}
+	case PhaseAuction:
+		if in.phase != PhaseAuction {
+			in.startAuction(0)
+		}
+	case PhaseHalted:
+		if in.phase != PhaseHalted {
+			in.halt(TradingHalted{Reason: HaltReasonRequested})
+		}
+	default:
+		in.publish(PhaseChangeRejected{Phase: change.Phase, Reason: RejectInvalidPhase})
+	}
+}
+
+// advancePhase ends a timed halt or auction that is due at engine time now.
+// A volatility halt hands over to a reopening auction when the instrument
+// has one configured.
+func (in *instrument) advancePhase(now int64) {
+	if in.phase == PhaseHalted && in.phaseEndsAt > 0 && now >= in.phaseEndsAt {
+		if duration := in.orderBook.config.AuctionDuration; duration > 0 {
+			in.startAuction(in.phaseEndsAt + duration)
+		} else {
+			in.resume()
+		}
+	}
+	if in.phase == PhaseAuction && in.phaseEndsAt > 0 && now >= in.phaseEndsAt {
+		in.uncross()
+	}
+}
+
+func (in *instrument) halt(halted TradingHalted) {
+	in.phase = PhaseHalted
+	in.phaseEndsAt = halted.ResumeAt
+	in.publish(halted)
+}
+
+// resume returns a halted instrument to continuous trading and releases any
+// stops the last trade before the halt had already reached.
+func (in *instrument) resume() {
+	in.phase = PhaseContinuous
+	in.phaseEndsAt = 0
+	in.publish(TradingResumed{})
+	in.triggerStopLossOrders()
+}
+
+func (in *instrument) startAuction(uncrossAt int64) {
+	in.phase = PhaseAuction
+	in.phaseEndsAt = uncrossAt
+	in.indicative = IndicativeAuctionPrice{}
+	in.publish(AuctionStarted{UncrossAt: uncrossAt})
+	in.publishIndicative()
+}
+
+// allowedInAuction reports whether an order type may be entered during a
+// call auction. Only orders that can wait on the book for the uncross are.
+func allowedInAuction(orderType string) bool {
+	switch orderType {
+	case "limit", "stop-loss", "stop-limit", "trailing-stop":
+		return true
+	}
+	return false
+}
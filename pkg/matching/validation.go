@@ -0,0 +1,142 @@
+package matching
+
+// validateOrder checks a new order against the instrument's config before it
+// reaches matching. It returns the reject reason, or "" if the order is valid.
+func (in *instrument) validateOrder(order *Order) string {
+	if order.Side != "buy" && order.Side != "sell" {
+		return RejectInvalidSide
+	}
+
+	switch order.Type {
+	case "market", "market-to-limit", "stop-loss", "trailing-stop":
+	case "limit", "stop-limit", "post-only", "aon", "fok", "ioc":
+		if order.Price <= 0 {
+			return RejectInvalidPrice
+		}
+	default:
+		return RejectInvalidType
+	}
+
+	if reason := in.validateQuantity(order.Quantity); reason != "" {
+		return reason
+	}
+	if reason := validateProtection(order); reason != "" {
+		return reason
+	}
+	if !validSelfTradePrevention(order.SelfTradePrevention) {
+		return RejectInvalidSelfTradePrevention
+	}
+	// An all-or-none iceberg would show, and trade, only its peak.
+	if order.DisplayQuantity < 0 || (order.DisplayQuantity > 0 && order.Type == "aon") {
+		return RejectInvalidDisplay
+	}
+
+	// Market orders carry no price, and a stop-loss or trailing stop may leave
+	// it out; anything that is given has to be a valid price.
+	if !isMarketType(order.Type) && order.Price != 0 {
+		if reason := in.validatePrice(order.Price); reason != "" {
+			return reason
+		}
+	}
+	if (order.Type == "stop-loss" || order.Type == "stop-limit") && order.TriggerPrice > 0 {
+		if reason := in.validatePrice(order.TriggerPrice); reason != "" {
+			return reason
+		}
+	}
+	if reason := in.validateNotional(notionalPrice(order), order.Quantity); reason != "" {
+		return reason
+	}
+
+	if in.orderBook.GetOrder(order.ID) != nil {
+		return RejectDuplicateOrderID
+	}
+	if _, ok := in.stopOrders[order.ID]; ok {
+		return RejectDuplicateOrderID
+	}
+	return ""
+}
+
+// validateQuantity checks a quantity against the lot size and the minimum and
+// maximum order quantity.
+func (in *instrument) validateQuantity(quantity int) string {
+	config := in.orderBook.config
+	if quantity <= 0 {
+		return RejectInvalidQuantity
+	}
+	if config.LotSize > 0 && quantity%config.LotSize != 0 {
+		return RejectInvalidLotSize
+	}
+	if config.MinQuantity > 0 && quantity < config.MinQuantity {
+		return RejectQuantityBelowMinimum
+	}
+	if config.MaxQuantity > 0 && quantity > config.MaxQuantity {
+		return RejectQuantityAboveMaximum
+	}
+	return ""
+}
+
+// validatePrice checks that a price is positive, on the tick grid of its
+// band and within the instrument's price range.
+func (in *instrument) validatePrice(price int64) string {
+	config := in.orderBook.config
+	if price <= 0 {
+		return RejectInvalidPrice
+	}
+	if in.orderBook.roundPrice(price) != price {
+		return RejectInvalidTickSize
+	}
+	if (config.MinPrice > 0 && price < config.MinPrice) || (config.MaxPrice > 0 && price > config.MaxPrice) {
+		return RejectPriceOutOfRange
+	}
+	return ""
+}
+
+// validateNotional checks price × quantity against the minimum notional. An
+// order without a reference price, such as a market order, cannot be checked.
+func (in *instrument) validateNotional(price int64, quantity int) string {
+	minNotional := in.orderBook.config.MinNotional
+	if minNotional > 0 && price > 0 && price*int64(quantity) < minNotional {
+		return RejectBelowMinNotional
+	}
+	return ""
+}
+
+// notionalPrice is the price an order's notional is measured at: its limit
+// price, or for a stop-market its trigger price.
+func notionalPrice(order *Order) int64 {
+	switch order.Type {
+	case "market", "market-to-limit", "trailing-stop":
+		return 0
+	case "stop-loss":
+		if order.TriggerPrice > 0 {
+			return order.TriggerPrice
+		}
+	}
+	return order.Price
+}
+
+// validateTriggeredOrder checks the order a stop releases. Its limit price
+// was checked on entry, but a stop-market is only now given a reference
+// price: the last trade that set it off.
+func (in *instrument) validateTriggeredOrder(order *Order) string {
+	if reason := in.validateQuantity(order.Quantity); reason != "" {
+		return reason
+	}
+	price := order.Price
+	if order.Type == "market" {
+		price = in.lastTradePrice
+	} else if reason := in.validatePrice(price); reason != "" {
+		return reason
+	} else if !in.withinPriceBand(price) {
+		return RejectPriceOutsideBand
+	}
+	return in.validateNotional(price, order.Quantity)
+}
+
+func (in *instrument) rejectOrder(order *Order, reason string) {
+	in.publish(OrderRejected{
+		OrderID:   order.ID,
+		OrdererID: order.OrdererID,
+		Reason:    reason,
+	})
+}
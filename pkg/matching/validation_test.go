@@ -0,0 +1,155 @@
+package matching
+
+import (
+	"testing"
+)
+
+func TestMatchingEngine_OrderValidation(t *testing.T) {
+	config := &OrderBookConfig{
+		MinTickSize: 100,
+		LotSize:     5,
+		MinQuantity: 10,
+		MaxQuantity: 1000,
+		MinPrice:    50 * PricePrecision,
+		MaxPrice:    150 * PricePrecision,
+	}
+
+	tests := []struct {
+		name   string
+		order  *Order
+		reason string
+	}{
+		{"unknown side", &Order{ID: 10, Type: "limit", Side: "hold", Price: 100 * PricePrecision, Quantity: 10}, RejectInvalidSide},
+		{"unknown type", &Order{ID: 10, Type: "twap", Side: "buy", Price: 100 * PricePrecision, Quantity: 10}, RejectInvalidType},
+		{"limit without a price", &Order{ID: 10, Type: "limit", Side: "buy", Quantity: 10}, RejectInvalidPrice},
+		{"zero quantity", &Order{ID: 10, Type: "limit", Side: "buy", Price: 100 * PricePrecision}, RejectInvalidQuantity},
+		{"negative quantity", &Order{ID: 10, Type: "market", Side: "buy", Quantity: -5}, RejectInvalidQuantity},
+		{"off the lot size", &Order{ID: 10, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 12}, RejectInvalidLotSize},
+		{"below the minimum quantity", &Order{ID: 10, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5}, RejectQuantityBelowMinimum},
+		{"above the maximum quantity", &Order{ID: 10, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 1005}, RejectQuantityAboveMaximum},
+		{"off the tick size", &Order{ID: 10, Type: "limit", Side: "buy", Price: 100*PricePrecision + 50, Quantity: 10}, RejectInvalidTickSize},
+		{"below the price range", &Order{ID: 10, Type: "limit", Side: "buy", Price: 40 * PricePrecision, Quantity: 10}, RejectPriceOutOfRange},
+		{"above the price range", &Order{ID: 10, Type: "limit", Side: "buy", Price: 160 * PricePrecision, Quantity: 10}, RejectPriceOutOfRange},
+		{"all-or-none with a display quantity", &Order{ID: 10, Type: "aon", Side: "buy", Price: 90 * PricePrecision, Quantity: 20, DisplayQuantity: 10}, RejectInvalidDisplay},
+		{"negative display quantity", &Order{ID: 10, Type: "limit", Side: "buy", Price: 90 * PricePrecision, Quantity: 20, DisplayQuantity: -5}, RejectInvalidDisplay},
+		{"trigger off the tick size", &Order{ID: 10, Type: "stop-limit", Side: "sell", Price: 90 * PricePrecision, TriggerPrice: 95*PricePrecision + 1, Quantity: 10}, RejectInvalidTickSize},
+		{"duplicate of a resting order", &Order{ID: 1, Type: "limit", Side: "buy", Price: 90 * PricePrecision, Quantity: 10}, RejectDuplicateOrderID},
+		{"duplicate of a pending stop", &Order{ID: 2, Type: "limit", Side: "buy", Price: 90 * PricePrecision, Quantity: 10}, RejectDuplicateOrderID},
+	}
+
+	for _, tt := range tests {
+		t.Run("should reject an order "+tt.name, func(t *testing.T) {
+			outputBuffer := newTestOutput()
+			me := NewMatchingEngineWithConfig(outputBuffer.buffer, config)
+			me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+			me.PlaceOrder(&Order{ID: 2, Type: "stop-loss", Side: "sell", Price: 90 * PricePrecision, Quantity: 10})
+
+			me.PlaceOrder(tt.order)
+
+			event, _ := outputBuffer.Pop()
+			if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != tt.reason {
+				t.Errorf("Expected a %s rejection, but got %v", tt.reason, event.Report)
+			}
+			if best := me.GetOrderBook().BestAsk(); best == nil || best.ID != 1 || best.Quantity != 10 {
+				t.Errorf("Expected the book to be untouched, got %+v", best)
+			}
+		})
+	}
+
+	t.Run("should accept a market order without a price", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, config)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.PlaceOrder(&Order{ID: 2, Type: "market", Side: "buy", Quantity: 10})
+
+		event, _ := outputBuffer.Pop()
+		if _, ok := event.Report.(Trade); !ok {
+			t.Errorf("Expected a trade, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should accept a new order once the old ID has left the book", func(t *testing.T) {
+		outputBuffer := newTestOutput()
+		me := NewMatchingEngineWithConfig(outputBuffer.buffer, config)
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		me.CancelOrder(&CancelRequest{OrderID: 1})
+		outputBuffer.Pop()
+
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "sell", Price: 100 * PricePrecision, Quantity: 10})
+		if outputBuffer.Size() != 0 {
+			event, _ := outputBuffer.Pop()
+			t.Errorf("Expected no rejection, but got %v", event.Report)
+		}
+	})
+}
+
+func TestMatchingEngine_InstrumentLimits(t *testing.T) {
+	config := &OrderBookConfig{
+		MinTickSize: 1,
+		TickLadder:  []TickBand{{MinPrice: 100 * PricePrecision, TickSize: 100}},
+		LotSize:     5,
+		MinNotional: 1000 * PricePrecision,
+	}
+	newEngine := func() (*MatchingEngine, *testOutput) {
+		outputBuffer := newTestOutput()
+		return NewMatchingEngineWithConfig(outputBuffer.buffer, config), outputBuffer
+	}
+
+	t.Run("should apply the tick size of the price band", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 99*PricePrecision + 1, Quantity: 20})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "sell", Price: 101*PricePrecision + 1, Quantity: 20})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.OrderID != 2 || rejected.Reason != RejectInvalidTickSize {
+			t.Errorf("Expected order 2 to be off tick, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should reject an order below the minimum notional", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 5})
+
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.Reason != RejectBelowMinNotional {
+			t.Errorf("Expected a min notional rejection, but got %v", event.Report)
+		}
+	})
+
+	t.Run("should enforce limits on amend", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, OrdererID: 7, Type: "limit", Side: "buy", Price: 100 * PricePrecision, Quantity: 20})
+
+		amends := []struct {
+			amend  *AmendRequest
+			reason string
+		}{
+			{&AmendRequest{OrderID: 1, OrdererID: 7, Price: 100*PricePrecision + 50}, RejectInvalidTickSize},
+			{&AmendRequest{OrderID: 1, OrdererID: 7, Quantity: 12}, RejectInvalidLotSize},
+			{&AmendRequest{OrderID: 1, OrdererID: 7, Quantity: 5}, RejectBelowMinNotional},
+		}
+		for _, a := range amends {
+			me.AmendOrder(a.amend)
+			event, _ := outputBuffer.Pop()
+			if rejected, ok := event.Report.(AmendRejected); !ok || rejected.Reason != a.reason {
+				t.Errorf("Expected a %s rejection, but got %v", a.reason, event.Report)
+			}
+		}
+		if best := me.GetOrderBook().BestBid(); best.Price != 100*PricePrecision || best.Quantity != 20 {
+			t.Errorf("Expected the order to be unchanged, got %+v", best)
+		}
+	})
+
+	t.Run("should check a stop-market's notional at the triggering trade", func(t *testing.T) {
+		me, outputBuffer := newEngine()
+		me.PlaceOrder(&Order{ID: 1, Type: "stop-loss", Side: "sell", TriggerPrice: 50 * PricePrecision, Quantity: 20})
+		me.PlaceOrder(&Order{ID: 2, Type: "limit", Side: "buy", Price: 40 * PricePrecision, Quantity: 25})
+		me.PlaceOrder(&Order{ID: 3, Type: "limit", Side: "sell", Price: 40 * PricePrecision, Quantity: 25})
+
+		outputBuffer.Pop() // trade between 2 and 3
+		event, _ := outputBuffer.Pop()
+		if rejected, ok := event.Report.(OrderRejected); !ok || rejected.OrderID != 1 || rejected.Reason != RejectBelowMinNotional {
+			t.Errorf("Expected the triggered stop to be rejected, but got %v", event.Report)
+		}
+	})
+}
@@ -1,6 +1,8 @@
 package streaming
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 )
 
@@ -11,6 +13,7 @@ import (
 // - For a more flexible solution, we could use a dynamic data structure, such as a slice or a linked list.
 // - We are using a mutex to protect the ring buffer from concurrent access. This is a simple solution, but it can be a bottleneck.
 // - For a high-performance system, we would want to use a lock-free data structure or a single-writer design.
+// - A full topic buffer refuses new events instead of overwriting ones that have not been polled yet. Recovery replays the event log on the assumption that the consumer saw every stored event, in order, so the bus must never drop one.
 type EventBus struct {
 	buffers      map[string]*topicBuffer
 	capacity     int
@@ -28,6 +31,17 @@ type topicBuffer struct {
 	capacity int
 }
 
+// ErrTopicFull is returned when a batch does not fit in what is left of its
+// topic's buffer. Nothing of the batch is stored; it can be retried once the
+// consumer has caught up.
+var ErrTopicFull = errors.New("event bus: topic buffer is full")
+
+// free returns how many more events the buffer can take. One slot stays
+// empty so a full buffer can be told from an empty one.
+func (t *topicBuffer) free() int {
+	return t.capacity - 1 - (t.tail-t.head+t.capacity)%t.capacity
+}
+
 func NewEventBus(capacity int, store *EventStore, topicManager *TopicManager) *EventBus {
 	bus := &EventBus{
 		buffers:      make(map[string]*topicBuffer),
@@ -50,14 +64,30 @@ func (b *EventBus) AddBatch(topicName string, payloads [][]byte) error {
 		return err
 	}
 
-	b.mutex.Lock()
-	defer b.mutex.Unlock()
-
+	// A batch is checked in full before any of it is stored, so it is
+	// either added as a whole or not at all.
 	for _, payload := range payloads {
 		if err := topic.Validate(payload); err != nil {
 			return err
 		}
+	}
 
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	buffer, ok := b.buffers[topicName]
+	if !ok {
+		buffer = &topicBuffer{
+			buffer:   make([]*Event, b.capacity),
+			capacity: b.capacity,
+		}
+		b.buffers[topicName] = buffer
+	}
+	if len(payloads) > buffer.free() {
+		return fmt.Errorf("%w: %s has room for %d of %d events", ErrTopicFull, topicName, buffer.free(), len(payloads))
+	}
+
+	for _, payload := range payloads {
 		event := &Event{
 			Topic:     topicName,
 			Timestamp: b.sequencer.Next(),
@@ -68,20 +98,8 @@ func (b *EventBus) AddBatch(topicName string, payloads [][]byte) error {
 			return err
 		}
 
-		buffer, ok := b.buffers[topicName]
-		if !ok {
-			buffer = &topicBuffer{
-				buffer:   make([]*Event, b.capacity),
-				capacity: b.capacity,
-			}
-			b.buffers[topicName] = buffer
-		}
-
 		buffer.buffer[buffer.tail] = event
 		buffer.tail = (buffer.tail + 1) % buffer.capacity
-		if buffer.tail == buffer.head {
-			buffer.head = (buffer.head + 1) % buffer.capacity
-		}
 	}
 
 	b.cond.Broadcast()
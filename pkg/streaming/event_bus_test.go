@@ -1,7 +1,9 @@
 package streaming
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -75,3 +77,52 @@ func TestEventBus(t *testing.T) {
 		t.Errorf("expected '{\"message\": \"test event 4\"}', got '%s'", string(events[1].Payload))
 	}
 }
+
+func TestEventBus_Full(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	store, err := NewEventStore(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	topicManager := NewTopicManager([]*Topic{{Name: "test", Schema: map[string]interface{}{"message": "string"}}})
+	bus := NewEventBus(4, store, topicManager)
+	event := func(message string) []byte {
+		return []byte(`{"message": "` + message + `"}`)
+	}
+
+	if err := bus.AddBatch("test", [][]byte{event("1"), event("2")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.AddBatch("test", [][]byte{event("3"), event("4")}); !errors.Is(err, ErrTopicFull) {
+		t.Fatalf("expected a batch that does not fit to be refused, got %v", err)
+	}
+	if err := bus.Add("test", event("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.Add("test", event("4")); !errors.Is(err, ErrTopicFull) {
+		t.Fatalf("expected a full topic to refuse an event, got %v", err)
+	}
+
+	events, err := bus.Poll("test", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || string(events[0].Payload) != string(event("1")) || string(events[2].Payload) != string(event("3")) {
+		t.Fatalf("expected events 1 to 3 with none overwritten, got %d events", len(events))
+	}
+
+	// Only what the consumer was given is in the log.
+	var stored int
+	if err := ReadEvents(path, func(*Event) error { stored++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 3 {
+		t.Errorf("expected 3 stored events, got %d", stored)
+	}
+
+	if err := bus.Add("test", event("4")); err != nil {
+		t.Errorf("expected room once the consumer caught up, got %v", err)
+	}
+}
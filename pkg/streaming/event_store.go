@@ -2,7 +2,13 @@ package streaming
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"sync"
 )
@@ -12,31 +18,79 @@ import (
 // - For a production system, we would want to use a more robust storage solution, such as a distributed database or a log-based message broker like Kafka.
 // - We are using gob for encoding, which is simple to use but not very efficient. For a high-performance system, we would want to use a more efficient encoding, such as protobuf or flatbuffers.
 // - We are using a mutex to protect the file from concurrent writes. This is a simple solution, but it can be a bottleneck. For a high-performance system, we would want to use a lock-free data structure or a single-writer design.
+// - The log is replayed to recover the matching engine, so it has to survive restarts and crashes.
+// - Each event is a self-contained record: its length, the gob-encoded event and a CRC-32. A gob stream cannot be continued by a new encoder, so this keeps the records of every run readable in one pass.
+// - The file starts with a magic and a format version, so a log written in another format is refused instead of misread. Logs from before the header (a bare gob stream) are reported as ErrLegacyLog.
+// - Every record is flushed as it is stored, so an event the bus has handed out is never lost when the process dies.
+// - A process killed mid-write leaves a torn record at the end of the file. It is cut off when the store is opened.
+// - A record that fails its checksum ends the log in the same way, so a damaged disk block does not keep the engine from starting. The bytes from that record on are first saved next to the log with a ".corrupt" suffix, so nothing is thrown away unseen.
 type EventStore struct {
-	file     *os.File
-	writer   *bufio.Writer
-	encoder  *gob.Encoder
-	mutex    sync.Mutex
-	enabled  bool
+	file    *os.File
+	writer  *bufio.Writer
+	record  bytes.Buffer
+	mutex   sync.Mutex
+	enabled bool
 }
 
+var (
+	// ErrCorruptRecord is returned when a stored record fails its checksum.
+	ErrCorruptRecord = errors.New("event store: corrupt record")
+	// ErrLegacyLog is returned for a log without the event log header, such
+	// as one written as a single gob stream by an older version.
+	ErrLegacyLog = errors.New("event store: not an event log, or written by an older version")
+
+	// errNoHeader means the file is empty, or a crash tore the header of a
+	// new log, so it holds no events yet.
+	errNoHeader = errors.New("event store: no header")
+)
+
+// Event log layout:
+//
+//	magic "MEEV" | version (uvarint) | records...
+//	record: length (uvarint) | gob-encoded Event | CRC-32 of the event (4 bytes, big endian)
+const (
+	eventLogMagic   = "MEEV"
+	eventLogVersion = 1
+)
+
+// maxRecordSize bounds the length read from a record header, so a corrupt
+// header cannot make the reader allocate without limit.
+const maxRecordSize = 64 << 20
+
 func NewEventStore(filePath string, enabled bool) (*EventStore, error) {
 	if !enabled {
 		return &EventStore{enabled: false}, nil
 	}
 
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return nil, err
 	}
 
-	writer := bufio.NewWriter(file)
-	encoder := gob.NewEncoder(writer)
+	r := bufio.NewReader(file)
+	end, err := readHeader(r)
+	if errors.Is(err, errNoHeader) {
+		end, err = writeHeader(file)
+	} else if err == nil {
+		end, err = readRecords(r, end, func(*Event) error { return nil })
+		if errors.Is(err, ErrCorruptRecord) {
+			err = saveCorruptTail(file, filePath+".corrupt", end)
+		}
+	}
+	if err == nil {
+		err = file.Truncate(end)
+	}
+	if err == nil {
+		_, err = file.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s", err, filePath)
+	}
 
 	return &EventStore{
 		file:    file,
-		writer:  writer,
-		encoder: encoder,
+		writer:  bufio.NewWriter(file),
 		enabled: true,
 	}, nil
 }
@@ -49,7 +103,21 @@ func (s *EventStore) Store(event *Event) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	return s.encoder.Encode(event)
+	s.record.Reset()
+	if err := gob.NewEncoder(&s.record).Encode(event); err != nil {
+		return err
+	}
+	var header [binary.MaxVarintLen64]byte
+	if _, err := s.writer.Write(header[:binary.PutUvarint(header[:], uint64(s.record.Len()))]); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write(s.record.Bytes()); err != nil {
+		return err
+	}
+	if err := binary.Write(s.writer, binary.BigEndian, crc32.ChecksumIEEE(s.record.Bytes())); err != nil {
+		return err
+	}
+	return s.writer.Flush()
 }
 
 func (s *EventStore) Close() error {
@@ -66,3 +134,138 @@ func (s *EventStore) Close() error {
 
 	return s.file.Close()
 }
+
+// ReadEvents calls fn for every event in an event store file, oldest first. A
+// torn record at the end of the file, left by a crash, is ignored. The file is
+// only read, so a corrupt record is reported rather than cut off; opening the
+// log with NewEventStore does that.
+func ReadEvents(filePath string, fn func(event *Event) error) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	start, err := readHeader(r)
+	if errors.Is(err, errNoHeader) {
+		return nil
+	}
+	if err == nil {
+		_, err = readRecords(r, start, fn)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, filePath)
+	}
+	return nil
+}
+
+// readHeader checks the magic and version at the start of the log and returns
+// the offset of the first record.
+func readHeader(r *bufio.Reader) (int64, error) {
+	magic, err := r.Peek(len(eventLogMagic))
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	if !bytes.HasPrefix([]byte(eventLogMagic), magic) {
+		return 0, ErrLegacyLog
+	}
+	if len(magic) < len(eventLogMagic) {
+		return 0, errNoHeader
+	}
+	r.Discard(len(eventLogMagic))
+
+	version, err := binary.ReadUvarint(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, errNoHeader
+	}
+	if err != nil {
+		return 0, err
+	}
+	if version != eventLogVersion {
+		return 0, fmt.Errorf("event store: unsupported log version %d", version)
+	}
+	return int64(len(eventLogMagic) + uvarintLen(version)), nil
+}
+
+// writeHeader starts a new log and returns the offset of its first record.
+func writeHeader(file *os.File) (int64, error) {
+	header := binary.AppendUvarint([]byte(eventLogMagic), eventLogVersion)
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	if _, err := file.WriteAt(header, 0); err != nil {
+		return 0, err
+	}
+	return int64(len(header)), nil
+}
+
+// saveCorruptTail copies the log from offset on to path before it is cut off.
+// An existing file is never overwritten, so an earlier copy is not lost.
+func saveCorruptTail(file *os.File, path string, offset int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	tail, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("event store: saving corrupt records from offset %d: %w", offset, err)
+	}
+	if _, err := io.Copy(tail, io.NewSectionReader(file, offset, info.Size()-offset)); err != nil {
+		tail.Close()
+		return err
+	}
+	if err := tail.Sync(); err != nil {
+		tail.Close()
+		return err
+	}
+	return tail.Close()
+}
+
+// readRecords decodes the records that start at offset end until the end of
+// the log or a torn record, and returns the offset just past the last complete
+// one.
+func readRecords(r *bufio.Reader, end int64, fn func(event *Event) error) (int64, error) {
+	var payload []byte
+	for {
+		length, err := binary.ReadUvarint(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return end, nil
+		}
+		if err != nil {
+			return end, err
+		}
+
+		if length > maxRecordSize {
+			return end, fmt.Errorf("%w at offset %d: length %d", ErrCorruptRecord, end, length)
+		}
+		if n := int(length) + 4; cap(payload) < n {
+			payload = make([]byte, n)
+		} else {
+			payload = payload[:n]
+		}
+		if _, err := io.ReadFull(r, payload); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return end, nil
+		} else if err != nil {
+			return end, err
+		}
+		data, checksum := payload[:length], binary.BigEndian.Uint32(payload[length:])
+		if crc32.ChecksumIEEE(data) != checksum {
+			return end, fmt.Errorf("%w at offset %d", ErrCorruptRecord, end)
+		}
+
+		var event Event
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&event); err != nil {
+			return end, fmt.Errorf("event store: record at offset %d: %w", end, err)
+		}
+		if err := fn(&event); err != nil {
+			return end, err
+		}
+		end += int64(uvarintLen(length)) + int64(length) + 4
+	}
+}
+
+func uvarintLen(v uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], v)
+}
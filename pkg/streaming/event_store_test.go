@@ -0,0 +1,158 @@
+package streaming
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEventStore(t *testing.T) {
+	storeEvents := func(t *testing.T, path string, payloads ...string) {
+		t.Helper()
+		store, err := NewEventStore(path, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, payload := range payloads {
+			if err := store.Store(&Event{Topic: "test", Payload: []byte(payload)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := store.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	readPayloads := func(t *testing.T, path string) []string {
+		t.Helper()
+		var payloads []string
+		err := ReadEvents(path, func(event *Event) error {
+			payloads = append(payloads, string(event.Payload))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return payloads
+	}
+
+	t.Run("reads back events stored across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.log")
+		storeEvents(t, path, "1", "2")
+		storeEvents(t, path, "3")
+
+		payloads := readPayloads(t, path)
+		if len(payloads) != 3 || payloads[0] != "1" || payloads[2] != "3" {
+			t.Errorf("expected [1 2 3], got %v", payloads)
+		}
+	})
+
+	t.Run("drops a torn record left by a crash", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.log")
+		storeEvents(t, path, "1", "2")
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, info.Size()-3); err != nil {
+			t.Fatal(err)
+		}
+
+		if payloads := readPayloads(t, path); len(payloads) != 1 || payloads[0] != "1" {
+			t.Errorf("expected [1], got %v", payloads)
+		}
+
+		// Reopening cuts the torn record off, so new events follow the last good one.
+		storeEvents(t, path, "3")
+		if payloads := readPayloads(t, path); len(payloads) != 2 || payloads[1] != "3" {
+			t.Errorf("expected [1 3], got %v", payloads)
+		}
+	})
+
+	t.Run("reports corrupt records", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.log")
+		storeEvents(t, path, "1", "2")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(data)/4] ^= 0xff
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err = ReadEvents(path, func(*Event) error { return nil })
+		if !errors.Is(err, ErrCorruptRecord) {
+			t.Errorf("expected a corrupt record error, got %v", err)
+		}
+	})
+	t.Run("cuts a corrupt record off when opened and keeps a copy", func(t *testing.T) {
+		dir := t.TempDir()
+		// A log holding only the first event gives the offset of the second.
+		storeEvents(t, filepath.Join(dir, "first.log"), "1")
+		first, err := os.ReadFile(filepath.Join(dir, "first.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(dir, "events.log")
+		storeEvents(t, path, "1", "2", "3")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[len(first)+3] ^= 0xff
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		storeEvents(t, path, "4")
+		if payloads := readPayloads(t, path); len(payloads) != 2 || payloads[0] != "1" || payloads[1] != "4" {
+			t.Errorf("expected [1 4], got %v", payloads)
+		}
+		tail, err := os.ReadFile(path + ".corrupt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(tail) != string(data[len(first):]) {
+			t.Errorf("expected the %d bytes from the corrupt record on to be kept, got %d", len(data)-len(first), len(tail))
+		}
+	})
+
+	t.Run("refuses a log without a header", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.log")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Older versions wrote the whole log as one gob stream.
+		if err := gob.NewEncoder(file).Encode(&Event{Topic: "test", Payload: []byte("1")}); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		if _, err := NewEventStore(path, true); !errors.Is(err, ErrLegacyLog) {
+			t.Errorf("expected a legacy log error from NewEventStore, got %v", err)
+		}
+		if err := ReadEvents(path, func(*Event) error { return nil }); !errors.Is(err, ErrLegacyLog) {
+			t.Errorf("expected a legacy log error from ReadEvents, got %v", err)
+		}
+	})
+
+	t.Run("rewrites a header torn by a crash", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.log")
+		if err := os.WriteFile(path, []byte(eventLogMagic[:2]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if payloads := readPayloads(t, path); len(payloads) != 0 {
+			t.Errorf("expected no events, got %v", payloads)
+		}
+
+		storeEvents(t, path, "1")
+		if payloads := readPayloads(t, path); len(payloads) != 1 || payloads[0] != "1" {
+			t.Errorf("expected [1], got %v", payloads)
+		}
+	})
+}
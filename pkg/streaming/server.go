@@ -2,10 +2,13 @@ package streaming
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"matching_engine/pkg/streaming/proto"
 )
@@ -28,7 +31,9 @@ func NewServer(eventBus *EventBus) *Server {
 }
 
 func (s *Server) Add(ctx context.Context, req *proto.AddRequest) (*proto.AddResponse, error) {
-	if err := s.eventBus.AddBatch(req.Topic, req.Payloads); err != nil {
+	if err := s.eventBus.AddBatch(req.Topic, req.Payloads); errors.Is(err, ErrTopicFull) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	} else if err != nil {
 		return nil, err
 	}
 	return &proto.AddResponse{}, nil